@@ -18,7 +18,17 @@ import (
 // @contact.name    Ivan Kuznetsov
 // @contact.email   kuznetsovivangio@gmail.com
 // @host            localhost:8080
-// @BasePath        /service
+// @BasePath        /
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+// @description                 JWT as "Bearer <token>". The subject is the user UUID, role "admin" grants access to every user.
+
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-API-Key
+// @description                 Key of a service-to-service client, limited to the read, write and cumulate scopes it was created with.
 
 func main() {
 	if err := app.Run(context.Background()); err != nil {
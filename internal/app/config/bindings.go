@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// binding ties a setting to its environment variable and flag.
+type binding struct {
+	env   string
+	flag  string
+	usage string
+	set   func(string) error
+}
+
+func (c *Config) bindings() []binding {
+	return []binding{
+		{"LOG_LEVEL", "log-level", "log level: debug, info, warn or error", stringValue(&c.LogLevel)},
+
+		{"APP_PORT", "port", "HTTP port", intValue(&c.HTTP.Port)},
+		{"HTTP_READ_TIMEOUT", "read-timeout", "how long reading a request may take", durationValue(&c.HTTP.ReadTimeout)},
+		{"HTTP_WRITE_TIMEOUT", "write-timeout", "how long writing a response may take", durationValue(&c.HTTP.WriteTimeout)},
+		{"HTTP_IDLE_TIMEOUT", "idle-timeout", "how long a keep-alive connection may idle", durationValue(&c.HTTP.IdleTimeout)},
+		{"SHUTDOWN_TIMEOUT_SECONDS", "shutdown-timeout-seconds", "seconds the requests in flight get to finish on shutdown", unitValue(&c.HTTP.ShutdownTimeout, time.Second)},
+		{"SHUTDOWN_DRAIN_DELAY", "shutdown-drain-delay", "how long to keep serving after /readyz fails on shutdown", durationValue(&c.HTTP.DrainDelay)},
+
+		{"DATABASE_CONN", "database-conn", "PostgreSQL connection string, replaces the other database settings", stringValue(&c.Database.Conn)},
+		{"DB_HOST", "db-host", "PostgreSQL host", stringValue(&c.Database.Host)},
+		{"POSTGRES_PORT", "db-port", "PostgreSQL port", intValue(&c.Database.Port)},
+		{"POSTGRES_USER", "db-user", "PostgreSQL user", stringValue(&c.Database.User)},
+		{"POSTGRES_PASSWORD", "db-password", "PostgreSQL password", stringValue(&c.Database.Password)},
+		{"POSTGRES_DB", "db-name", "PostgreSQL database", stringValue(&c.Database.Name)},
+		{"DB_READY_TIMEOUT", "db-ready-timeout", "how long to wait for the database on startup", durationValue(&c.Database.ReadyTimeout)},
+		{"DB_SLOW_QUERY_THRESHOLD", "db-slow-query-threshold", "queries slower than this are logged as warnings, 0 turns it off", durationValue(&c.Database.SlowQueryThreshold)},
+		{"MIGRATIONS_TABLE", "migrations-table", "table of the applied migrations", stringValue(&c.Database.MigrationsTable)},
+
+		{"JWT_SECRET", "jwt-secret", "HS256 secret of the bearer tokens", stringValue(&c.Auth.JWTSecret)},
+		{"JWT_PUBLIC_KEY_FILE", "jwt-public-key-file", "PEM file with the RS256 public key of the bearer tokens", stringValue(&c.Auth.JWTPublicKeyFile)},
+
+		{"IDEMPOTENCY_KEY_TTL_HOURS", "idempotency-key-ttl-hours", "hours a response is replayed for its Idempotency-Key", unitValue(&c.IdempotencyKeyTTL, time.Hour)},
+
+		{"OTEL_TRACES_EXPORTER", "traces-exporter", "trace exporter: otlp, stdout or none", stringValue(&c.Tracing.Exporter)},
+	}
+}
+
+func stringValue(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+func intValue(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func durationValue(p *time.Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 15s", s)
+		}
+		*p = d
+		return nil
+	}
+}
+
+// unitValue reads a whole number of units, as the settings that predate the
+// durations are given.
+func unitValue(p *time.Duration, unit time.Duration) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		*p = time.Duration(n) * unit
+		return nil
+	}
+}
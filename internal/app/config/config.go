@@ -0,0 +1,225 @@
+// Package config loads the settings of the service from a YAML file, the
+// environment and the command line, each overriding the one before.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[REDACTED]"
+
+type Config struct {
+	LogLevel          string         `yaml:"log_level"`
+	HTTP              HTTPConfig     `yaml:"http"`
+	Database          DatabaseConfig `yaml:"database"`
+	Auth              AuthConfig     `yaml:"auth"`
+	IdempotencyKeyTTL time.Duration  `yaml:"idempotency_key_ttl"`
+	Tracing           TracingConfig  `yaml:"tracing"`
+}
+
+type HTTPConfig struct {
+	Port            int           `yaml:"port"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// DrainDelay is how long the service keeps serving after /readyz fails
+	// on shutdown, for the orchestrator to stop sending requests.
+	DrainDelay time.Duration `yaml:"drain_delay"`
+}
+
+// DatabaseConfig locates PostgreSQL either by Conn or by the parts of it.
+type DatabaseConfig struct {
+	Conn               string        `yaml:"conn"`
+	Host               string        `yaml:"host"`
+	Port               int           `yaml:"port"`
+	User               string        `yaml:"user"`
+	Password           string        `yaml:"password"`
+	Name               string        `yaml:"name"`
+	ReadyTimeout       time.Duration `yaml:"ready_timeout"`
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold"`
+	MigrationsTable    string        `yaml:"migrations_table"`
+}
+
+// AuthConfig holds the keys of the bearer tokens: the HS256 secret and the
+// PEM file with the RS256 public key.
+type AuthConfig struct {
+	JWTSecret        string `yaml:"jwt_secret"`
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+}
+
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout" or "none"; the other OTEL_* variables
+	// are left to the OpenTelemetry SDK.
+	Exporter string `yaml:"exporter"`
+}
+
+func Default() *Config {
+	return &Config{
+		LogLevel: "info",
+		HTTP: HTTPConfig{
+			Port:            8080,
+			ReadTimeout:     15 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+			DrainDelay:      5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Port:               5432,
+			ReadyTimeout:       30 * time.Second,
+			SlowQueryThreshold: time.Second,
+			MigrationsTable:    "schema_migrations_effectivemobiletest",
+		},
+		IdempotencyKeyTTL: 24 * time.Hour,
+		Tracing:           TracingConfig{Exporter: "none"},
+	}
+}
+
+// Load reads the file named by the -config flag or CONFIG_FILE, if any, then
+// the environment, then the flags in args. It reports every invalid setting
+// at once; flag.ErrHelp means the usage was asked for and printed.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	bindings := cfg.bindings()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML file with the settings, also CONFIG_FILE")
+	flagValues := make(map[string]string)
+	for _, b := range bindings {
+		fs.Func(b.flag, b.usage+", also "+b.env, func(s string) error {
+			flagValues[b.flag] = s
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, b := range bindings {
+		if s, ok := os.LookupEnv(b.env); ok && s != "" {
+			if err := b.set(s); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.env, err))
+			}
+		}
+	}
+	for _, b := range bindings {
+		if s, ok := flagValues[b.flag]; ok {
+			if err := b.set(s); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", b.flag, err))
+			}
+		}
+	}
+	errs = append(errs, cfg.Validate())
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, err := logrus.ParseLevel(c.LogLevel)
+	check(err == nil, "log_level: unknown level %q", c.LogLevel)
+
+	check(c.HTTP.Port > 0 && c.HTTP.Port < 1<<16, "http.port: %d is not a port", c.HTTP.Port)
+	check(c.HTTP.ReadTimeout > 0, "http.read_timeout: must be positive")
+	check(c.HTTP.WriteTimeout > 0, "http.write_timeout: must be positive")
+	check(c.HTTP.IdleTimeout > 0, "http.idle_timeout: must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "http.shutdown_timeout: must be positive")
+	check(c.HTTP.DrainDelay >= 0, "http.drain_delay: must not be negative")
+
+	if c.Database.Conn == "" {
+		check(c.Database.Host != "", "database.host: required unless database.conn is set")
+		check(c.Database.User != "", "database.user: required unless database.conn is set")
+		check(c.Database.Password != "", "database.password: required unless database.conn is set")
+		check(c.Database.Name != "", "database.name: required unless database.conn is set")
+		check(c.Database.Port > 0 && c.Database.Port < 1<<16, "database.port: %d is not a port", c.Database.Port)
+	}
+	check(c.Database.ReadyTimeout > 0, "database.ready_timeout: must be positive")
+	check(c.Database.SlowQueryThreshold >= 0, "database.slow_query_threshold: must not be negative")
+	check(c.Database.MigrationsTable != "", "database.migrations_table: required")
+
+	check(c.Auth.JWTSecret != "" || c.Auth.JWTPublicKeyFile != "", "auth: jwt_secret or jwt_public_key_file is required")
+
+	check(c.IdempotencyKeyTTL > 0, "idempotency_key_ttl: must be positive")
+
+	check(slices.Contains([]string{"none", "otlp", "stdout"}, c.Tracing.Exporter),
+		"tracing.exporter: unknown exporter %q", c.Tracing.Exporter)
+
+	return errors.Join(errs...)
+}
+
+// DBConn is the PostgreSQL connection string.
+func (c *DatabaseConfig) DBConn() string {
+	if c.Conn != "" {
+		return c.Conn
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.User, c.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:     c.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return u.String()
+}
+
+// Redacted is a copy of c safe to log.
+func (c *Config) Redacted() *Config {
+	r := *c
+	if r.Database.Password != "" {
+		r.Database.Password = redacted
+	}
+	if r.Database.Conn != "" {
+		if u, err := url.Parse(r.Database.Conn); err == nil && u.User != nil {
+			r.Database.Conn = u.Redacted()
+		} else if strings.Contains(r.Database.Conn, "password") {
+			r.Database.Conn = redacted
+		}
+	}
+	if r.Auth.JWTSecret != "" {
+		r.Auth.JWTSecret = redacted
+	}
+	return &r
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLayersFileEnvAndFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := `
+http:
+  port: 9000
+  read_timeout: 5s
+database:
+  conn: postgres://app:s3cret@db:5432/app_db?sslmode=disable
+  migrations_table: from_file
+auth:
+  jwt_secret: file-secret
+`
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("MIGRATIONS_TABLE", "from_env")
+	t.Setenv("APP_PORT", "9001")
+
+	cfg, err := Load([]string{"-port", "9002", "-read-timeout", "7s"})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.HTTP.Port != 9002 || cfg.HTTP.ReadTimeout != 7*time.Second {
+		t.Errorf("flags: got port %d and read timeout %s, want 9002 and 7s", cfg.HTTP.Port, cfg.HTTP.ReadTimeout)
+	}
+	if cfg.Database.MigrationsTable != "from_env" {
+		t.Errorf("env: got migrations table %q, want from_env", cfg.Database.MigrationsTable)
+	}
+	if cfg.Auth.JWTSecret != "file-secret" || cfg.HTTP.WriteTimeout != 15*time.Second {
+		t.Errorf("file and defaults: got secret %q and write timeout %s", cfg.Auth.JWTSecret, cfg.HTTP.WriteTimeout)
+	}
+
+	logged := strings.Join([]string{cfg.Redacted().Database.Conn, cfg.Redacted().Auth.JWTSecret}, " ")
+	if strings.Contains(logged, "s3cret") || strings.Contains(logged, "file-secret") {
+		t.Errorf("secrets leak into the redacted config: %s", logged)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	for _, env := range []string{"CONFIG_FILE", "DATABASE_CONN", "DB_HOST", "JWT_SECRET", "JWT_PUBLIC_KEY_FILE"} {
+		t.Setenv(env, "")
+	}
+	t.Setenv("LOG_LEVEL", "loud")
+
+	_, err := Load([]string{"-port", "0", "-shutdown-drain-delay", "-1s", "-traces-exporter", "jaeger"})
+	if err == nil {
+		t.Fatal("Load accepted an invalid config")
+	}
+	for _, want := range []string{"log_level", "http.port", "http.drain_delay", "database.host", "auth", "tracing.exporter"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+}
@@ -10,67 +10,79 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/Owouwun/effectivemobiletest/internal/app/config"
 	"github.com/Owouwun/effectivemobiletest/internal/core/api/handlers"
 	"github.com/Owouwun/effectivemobiletest/internal/core/api/middleware"
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/health"
 	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/metrics"
 	repository_services "github.com/Owouwun/effectivemobiletest/internal/core/repository/services"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"gorm.io/gorm"
+	"gorm.io/plugin/opentelemetry/tracing"
 )
 
 type App struct {
 	router          *gin.Engine
 	db              *gorm.DB
 	srv             *http.Server
+	readiness       *health.Readiness
+	drainDelay      time.Duration
 	shutdownTimeout time.Duration
 
 	sigCh chan os.Signal
 }
 
-func ConfigLogging() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	switch logLevel {
-	case "DEBUG":
-		logrus.SetLevel(logrus.DebugLevel)
+func ConfigLogging(cfg *config.Config) {
+	level, _ := logrus.ParseLevel(cfg.LogLevel)
+	logrus.SetLevel(level)
+	if level >= logrus.DebugLevel {
 		logrus.SetFormatter(&logrus.TextFormatter{
 			DisableQuote: true,
 		})
-	default:
-		logrus.SetLevel(logrus.InfoLevel)
+	} else {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
 	}
 
 	logrus.Infof("Log level: %s", logrus.GetLevel().String())
 }
 
-func BuildDBConnFromConfig() (string, error) {
-	conn := os.Getenv("DATABASE_CONN")
-	if conn != "" {
-		return conn, nil
+// BuildJWTConfig reads the bearer token keys: the HS256 secret and the PEM
+// encoded RSA public key for RS256.
+func BuildJWTConfig(cfg *config.AuthConfig) (*middleware.JWTConfig, error) {
+	jwtCfg := &middleware.JWTConfig{
+		Secret: []byte(cfg.JWTSecret),
 	}
-	user := os.Getenv("POSTGRES_USER")
-	pass := os.Getenv("POSTGRES_PASSWORD")
-	host := os.Getenv("DB_HOST")
-	dbName := os.Getenv("POSTGRES_DB")
-	if user == "" || pass == "" || host == "" || dbName == "" {
-		return "", fmt.Errorf("missing DB secret envs")
+
+	if cfg.JWTPublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+		}
+		if jwtCfg.PublicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("failed to parse JWT public key: %w", err)
+		}
 	}
 
-	conn = fmt.Sprintf("postgres://%s:%s@%s:5432/%s?sslmode=disable", user, pass, host, dbName)
-	return conn, nil
+	return jwtCfg, nil
 }
 
-func PrepareDB(dbConn string) (*gorm.DB, error) {
+func PrepareDB(cfg *config.DatabaseConfig) (*gorm.DB, error) {
 	logrus.Info("Preparing database...")
-	if err := waitForDBReady(dbConn); err != nil {
+	dbConn := cfg.DBConn()
+	if err := waitForDBReady(dbConn, cfg.ReadyTimeout); err != nil {
 		return nil, fmt.Errorf("failed to wait for database: %w", err)
 	}
 
-	if err := runMigrations(dbConn); err != nil {
+	if err := runMigrations(dbConn, cfg.MigrationsTable); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
-	db, err := connectToDB(dbConn)
+	db, err := connectToDB(dbConn, cfg.SlowQueryThreshold)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -79,72 +91,150 @@ func PrepareDB(dbConn string) (*gorm.DB, error) {
 	return db, nil
 }
 
-func PrepareRouter(db *gorm.DB) *gin.Engine {
-	logrus.Info("Preparing routers...")
+// PrepareReadiness expects the schema at the version of the latest migration
+// the service ships with.
+func PrepareReadiness(db *gorm.DB, cfg *config.DatabaseConfig) (*health.Readiness, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
 
-	router := gin.Default()
+	version, err := latestMigrationVersion(getMigrationsPath())
+	if err != nil {
+		return nil, err
+	}
+
+	return health.NewReadiness(sqlDB, cfg.MigrationsTable, version), nil
+}
+
+func PrepareRouter(cfg *config.Config, db *gorm.DB, jwtCfg *middleware.JWTConfig, readiness handlers.ReadinessProbe) *gin.Engine {
+	logrus.Info("Preparing routers...")
 
 	if logrus.IsLevelEnabled(logrus.DebugLevel) {
 		gin.SetMode(gin.DebugMode)
-		router.Use(middleware.DebugRequestLogger())
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.HandleMethodNotAllowed = true
+
+	registry := metrics.NewRegistry()
+	router.Use(
+		middleware.RequestID(),
+		otelgin.Middleware(ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+			switch c.FullPath() {
+			case "/metrics", "/healthz", "/readyz":
+				return false
+			}
+			return true
+		})),
+		// After otelgin, which restores the request context it replaced, so
+		// that the log sees the caller the auth middleware adds.
+		middleware.AccessLog("/metrics", "/healthz", "/readyz"),
+		middleware.Metrics(registry),
+		middleware.Recovery(),
+	)
+	router.NoRoute(func(c *gin.Context) {
+		problem.WriteProblem(c, problem.New(http.StatusNotFound, "no-route", "No route", "no route matches "+c.Request.URL.Path), nil)
+	})
+	router.NoMethod(func(c *gin.Context) {
+		problem.WriteProblem(c, problem.New(http.StatusMethodNotAllowed, "method-not-allowed", "Method not allowed", c.Request.Method+" is not allowed on "+c.Request.URL.Path), nil)
+	})
+
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		router.Use(middleware.DebugRequestLogger())
+	}
+
 	serviceRepo := repository_services.NewServiceRepository(db)
-	subscriptionService := services.NewSubscriptionService(serviceRepo)
+	exchangeRateRepo := repository_services.NewExchangeRateRepository(db)
+	subscriptionService := services.NewSubscriptionService(serviceRepo, exchangeRateRepo)
 	subscriptionHandler := handlers.NewSubscriptionHandler(subscriptionService)
+	exchangeRateService := services.NewExchangeRateService(exchangeRateRepo)
+	exchangeRateHandler := handlers.NewExchangeRateHandler(exchangeRateService)
+	apiKeyService := services.NewAPIKeyService(repository_services.NewAPIKeyRepository(db))
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	idempotencyService := services.NewIdempotencyService(repository_services.NewIdempotencyRepository(db), cfg.IdempotencyKeyTTL)
+
+	if err := metrics.RegisterDB(registry, db); err != nil {
+		logrus.Warnf("Database metrics are unavailable: %v", err)
+	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics(), tracing.WithoutQueryVariables())); err != nil {
+		logrus.Warnf("Database tracing is unavailable: %v", err)
+	}
+	registry.MustRegister(metrics.NewSubscriptionCollector(subscriptionService))
+	router.GET("/metrics", gin.WrapH(metrics.Handler(registry)))
+
+	healthHandler := handlers.NewHealthHandler(readiness)
+	router.GET("/healthz", healthHandler.Healthz)
+	router.GET("/readyz", healthHandler.Readyz)
+
+	authenticate := middleware.Authenticate(jwtCfg, apiKeyService)
+	adminOnly := middleware.RequireRole(services.RoleAdmin)
+	canRead := middleware.RequireScope(services.ScopeRead)
+	canWrite := middleware.RequireScope(services.ScopeWrite)
+	canCumulate := middleware.RequireScope(services.ScopeCumulate)
 
-	apiOrders := router.Group("/service")
+	apiOrders := router.Group("/service", authenticate)
 	{
-		apiOrders.POST("", subscriptionHandler.CreateService)
-		apiOrders.GET("/:id", subscriptionHandler.GetService)
-		apiOrders.GET("", subscriptionHandler.GetServices)
-		apiOrders.PATCH("/:id", subscriptionHandler.UpdateService)
-		apiOrders.DELETE("/:id", subscriptionHandler.DeleteService)
-		apiOrders.GET("/cumulate", subscriptionHandler.CumulateServices)
+		apiOrders.POST("", canWrite, middleware.Idempotency(idempotencyService), subscriptionHandler.CreateService)
+		apiOrders.GET("/:id", canRead, subscriptionHandler.GetService)
+		apiOrders.GET("", canRead, subscriptionHandler.GetServices)
+		apiOrders.GET("/export", canRead, subscriptionHandler.ExportServices)
+		apiOrders.PATCH("/:id", canWrite, subscriptionHandler.UpdateService)
+		apiOrders.PUT("/:id", canWrite, subscriptionHandler.ReplaceService)
+		apiOrders.DELETE("/:id", canWrite, subscriptionHandler.DeleteService)
+		apiOrders.POST("/:id/restore", canWrite, subscriptionHandler.RestoreService)
+		apiOrders.POST("/purge", adminOnly, subscriptionHandler.PurgeDeletedServices)
+		apiOrders.POST("/batch", canWrite, subscriptionHandler.ApplyBatch)
+		apiOrders.POST("/import", canWrite, subscriptionHandler.ImportServices)
+		apiOrders.POST("/:id/prices", canWrite, subscriptionHandler.SchedulePriceChange)
+		apiOrders.GET("/:id/prices", canRead, subscriptionHandler.GetServicePrices)
+		apiOrders.GET("/:id/history", canRead, subscriptionHandler.GetServiceHistory)
+		apiOrders.GET("/cumulate", canCumulate, subscriptionHandler.CumulateServices)
+		apiOrders.GET("/cumulate/breakdown", canCumulate, subscriptionHandler.CumulateServicesBreakdown)
 	}
 
-	logrus.Info("Successful routers preparing!")
-	return router
-}
-
-func GetAppAddr() string {
-	appPort := os.Getenv("APP_PORT")
-	if appPort == "" {
-		appPort = "8080"
-		logrus.Infof("APP_PORT is not set, using default: %s", appPort)
+	apiRates := router.Group("/rates", authenticate)
+	{
+		apiRates.POST("", adminOnly, exchangeRateHandler.CreateExchangeRate)
+		apiRates.GET("/:id", canRead, exchangeRateHandler.GetExchangeRate)
+		apiRates.GET("", canRead, exchangeRateHandler.GetExchangeRates)
+		apiRates.PUT("/:id", adminOnly, exchangeRateHandler.UpdateExchangeRate)
+		apiRates.DELETE("/:id", adminOnly, exchangeRateHandler.DeleteExchangeRate)
 	}
-	return ":" + appPort
-}
 
-func GetShutdownTimeout() time.Duration {
-	if s := os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"); s != "" {
-		if secs, err := strconv.Atoi(s); err == nil && secs > 0 {
-			return time.Duration(secs) * time.Second
-		}
-		logrus.Warnf("Invalid SHUTDOWN_TIMEOUT_SECONDS=%s, using default", s)
+	apiKeys := router.Group("/api-keys", authenticate, adminOnly)
+	{
+		apiKeys.POST("", apiKeyHandler.CreateAPIKey)
+		apiKeys.GET("", apiKeyHandler.GetAPIKeys)
+		apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
 	}
-	return 30 * time.Second
+
+	logrus.Info("Successful routers preparing!")
+	return router
 }
 
-func NewApp(router *gin.Engine, db *gorm.DB, addr string, shutdownTimeout time.Duration) *App {
+func NewApp(router *gin.Engine, db *gorm.DB, readiness *health.Readiness, cfg *config.HTTPConfig) *App {
 	return &App{
 		router:          router,
 		db:              db,
-		srv:             newHTTPServer(addr, router),
-		shutdownTimeout: shutdownTimeout,
+		readiness:       readiness,
+		drainDelay:      cfg.DrainDelay,
+		srv:             newHTTPServer(cfg, router),
+		shutdownTimeout: cfg.ShutdownTimeout,
 		sigCh:           make(chan os.Signal, 1),
 	}
 }
 
-func newHTTPServer(addr string, handler http.Handler) *http.Server {
+func newHTTPServer(cfg *config.HTTPConfig, handler http.Handler) *http.Server {
 	return &http.Server{
-		Addr:         addr,
+		Addr:         ":" + strconv.Itoa(cfg.Port),
 		Handler:      handler,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
 }
 
@@ -169,6 +259,8 @@ func (a *App) waitForSignalOrListenError(listenErrCh chan error) error {
 	select {
 	case sig := <-a.sigCh:
 		logrus.Infof("Received signal %s, beginning shutdown", sig.String())
+		a.readiness.ShutDown()
+		a.drain()
 		return nil
 	case err := <-listenErrCh:
 		if err != nil {
@@ -180,6 +272,16 @@ func (a *App) waitForSignalOrListenError(listenErrCh chan error) error {
 	}
 }
 
+// drain keeps serving while the orchestrator notices that the service is no
+// longer ready and stops routing requests to it.
+func (a *App) drain() {
+	if a.drainDelay <= 0 {
+		return
+	}
+	logrus.Infof("Draining requests for %s before stopping the HTTP server", a.drainDelay)
+	time.Sleep(a.drainDelay)
+}
+
 func (a *App) shutdown(parentCtx context.Context) error {
 	ctx, cancel := context.WithTimeout(parentCtx, a.shutdownTimeout)
 	defer cancel()
@@ -3,27 +3,25 @@ package helpers
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"time"
 
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/sirupsen/logrus"
 	gorm_postgres "gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-const (
-	dbConnectionReadyTimeout = 30 * time.Second
-)
-
-func waitForDBReady(dbConn string) error {
+func waitForDBReady(dbConn string, timeout time.Duration) error {
 	logrus.Info("Waiting for database to be ready...")
-	ctx, cancel := context.WithTimeout(context.Background(), dbConnectionReadyTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	ticker := time.NewTicker(100 * time.Millisecond)
@@ -48,18 +46,11 @@ func waitForDBReady(dbConn string) error {
 	}
 }
 
-func connectToDB(dbConn string) (*gorm.DB, error) {
+func connectToDB(dbConn string, slowQueryThreshold time.Duration) (*gorm.DB, error) {
 	logrus.Info("Connecting to the PostgreSQL database...")
-	newLogger := logger.New(
-		log.New(os.Stdout, "\r\n", log.LstdFlags),
-		logger.Config{
-			SlowThreshold: time.Second,
-			LogLevel:      logger.Info,
-			Colorful:      true,
-		},
-	)
 	db, err := gorm.Open(gorm_postgres.Open(dbConn), &gorm.Config{
-		Logger: newLogger,
+		Logger:         logging.NewGormLogger(slowQueryThreshold),
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, err
@@ -69,15 +60,38 @@ func connectToDB(dbConn string) (*gorm.DB, error) {
 	return db, nil
 }
 
-func runMigrations(dbConn string) error {
-	migrationsTable := os.Getenv("MIGRATIONS_TABLE")
-	if migrationsTable == "" {
-		logrus.Info("Migration table was not set, use default")
-		migrationsTable = "schema_migrations_effectivemobiletest"
+func getMigrationsPath() string {
+	wd, _ := os.Getwd()
+	return "file://" + filepath.Join(wd, "migrations")
+}
+
+// latestMigrationVersion is the version the migrations shipped with the
+// service bring the schema to.
+func latestMigrationVersion(migrationsPath string) (uint, error) {
+	src, err := source.Open(migrationsPath)
+	if err != nil {
+		return 0, fmt.Errorf("source.Open failed: %w", err)
 	}
+	defer src.Close()
 
-	wd, _ := os.Getwd()
-	migrationsPath := "file://" + filepath.Join(wd, "migrations")
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("no migrations found: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, os.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		version = next
+	}
+}
+
+func runMigrations(dbConn, migrationsTable string) error {
+	migrationsPath := getMigrationsPath()
 	logrus.Infof("Running database migrations; migrationsPath=%s migrationsTable=%s", migrationsPath, migrationsTable)
 
 	sqlDB, err := sql.Open("postgres", dbConn)
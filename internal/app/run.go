@@ -2,8 +2,13 @@ package app
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/Owouwun/effectivemobiletest/internal/app/config"
 	helpers "github.com/Owouwun/effectivemobiletest/internal/app/helpers"
 	"github.com/sirupsen/logrus"
 	swaggerFiles "github.com/swaggo/files"
@@ -11,24 +16,48 @@ import (
 )
 
 func Run(ctx context.Context) error {
-	helpers.ConfigLogging()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	helpers.ConfigLogging(cfg)
+	logrus.Infof("Effective configuration: %+v", *cfg.Redacted())
+
+	shutdownTracing, err := helpers.SetupTracing(ctx, &cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logrus.Errorf("Failed to flush traces: %v", err)
+		}
+	}()
 
-	dbConn, err := helpers.BuildDBConnFromConfig()
+	jwtCfg, err := helpers.BuildJWTConfig(&cfg.Auth)
 	if err != nil {
-		return fmt.Errorf("failed to build DB connection string: %w", err)
+		return fmt.Errorf("failed to build JWT config: %w", err)
 	}
 
-	db, err := helpers.PrepareDB(dbConn)
+	db, err := helpers.PrepareDB(&cfg.Database)
 	if err != nil {
 		return fmt.Errorf("failed to prepare database: %w", err)
 	}
 
-	router := helpers.PrepareRouter(db)
+	readiness, err := helpers.PrepareReadiness(db, &cfg.Database)
+	if err != nil {
+		return fmt.Errorf("failed to prepare readiness probe: %w", err)
+	}
+
+	router := helpers.PrepareRouter(cfg, db, jwtCfg, readiness)
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	addr := helpers.GetAppAddr()
-	shutdownTimeout := helpers.GetShutdownTimeout()
-	a := helpers.NewApp(router, db, addr, shutdownTimeout)
+	a := helpers.NewApp(router, db, readiness, &cfg.HTTP)
 
 	logrus.Infof("App constructed; delegating run to App.Run")
 	return a.Run(ctx)
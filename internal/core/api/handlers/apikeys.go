@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type APIKeyService interface {
+	CreateAPIKey(ctx context.Context, key *services.APIKey) (string, error)
+	GetAPIKeys(ctx context.Context) ([]*services.APIKey, error)
+	RevokeAPIKey(ctx context.Context, ID uuid.UUID) error
+}
+
+type APIKeyHandler struct {
+	apiKeyService APIKeyService
+}
+
+func NewAPIKeyHandler(aks APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: aks,
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string           `json:"name" example:"billing-export"`
+	Scopes    []services.Scope `json:"scopes" example:"read,cumulate"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty" example:"2026-01-01T00:00:00Z"`
+}
+
+type CreateAPIKeyResponse struct {
+	*services.APIKey
+	// Key is the plaintext key; it is shown only in this response.
+	Key string `json:"key" example:"emk_3f9a1cV2lQ..."`
+}
+
+// CreateAPIKey godoc
+// @Summary      Create an API key
+// @Description  Creates a key for a service-to-service client, sent in the X-API-Key header. The plaintext key is returned only once, only its hash is stored.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Param        request  body  CreateAPIKeyRequest  true  "Name, scopes and optional expiry"
+// @Success      201  {object}  CreateAPIKeyResponse
+// @Failure      400  {object}  problem.Problem  "Invalid request body, scope or expiry"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can manage API keys"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return
+	}
+
+	key := &services.APIKey{
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	plaintext, err := h.apiKeyService.CreateAPIKey(c, key)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, CreateAPIKeyResponse{APIKey: key, Key: plaintext})
+}
+
+// GetAPIKeys godoc
+// @Summary      List API keys
+// @Description  Lists every API key including revoked and expired ones, without the secrets.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Success      200  {array}   services.APIKey
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can manage API keys"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /api-keys [get]
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeyService.GetAPIKeys(c)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeAPIKey godoc
+// @Summary      Revoke an API key
+// @Description  Revokes the key at once; it stays listed with its revocation time.
+// @Tags         api-keys
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the API key" example:"123e4567-e89b-12d3-a456-426614174020"
+// @Success      204  "Successfully revoked the API key"
+// @Failure      400  {object}  problem.Problem  "Invalid UUID"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can manage API keys"
+// @Failure      404  {object}  problem.Problem  "API key not found"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(c, ID); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
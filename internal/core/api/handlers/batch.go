@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type BatchRequest struct {
+	Mode       string                  `json:"mode,omitempty" example:"atomic" enums:"atomic,best_effort"`
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+// BatchOperationRequest carries a CreateRequest in Service for a create, the
+// merge patch of an UpdateRequest for an update and nothing for a delete.
+type BatchOperationRequest struct {
+	Op      string          `json:"op" example:"create" enums:"create,update,delete"`
+	ID      string          `json:"id,omitempty" example:"123e4567-e89b-12d3-a456-426614174009"`
+	Service json.RawMessage `json:"service,omitempty" swaggertype:"object"`
+}
+
+// BatchItemResult holds the status and body the single operation handler
+// would answer with.
+type BatchItemResult struct {
+	Index   int               `json:"index" example:"0"`
+	Op      string            `json:"op" example:"create"`
+	Status  int               `json:"status" example:"201"`
+	Service *services.Service `json:"service,omitempty"`
+	Error   *problem.Problem  `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Mode    services.BatchMode `json:"mode" example:"atomic"`
+	Results []*BatchItemResult `json:"results"`
+}
+
+func (r *BatchItemResult) fail(err error) {
+	r.Error = problem.FromError(err)
+	r.Status = r.Error.Status
+}
+
+// batchOperation validates an operation of the request like the single
+// operation handlers validate their requests.
+func batchOperation(req *BatchOperationRequest) (*services.BatchOperation, *requestError) {
+	op, err := services.ParseBatchOp(req.Op)
+	if err != nil {
+		return nil, &requestError{"invalid operation", err}
+	}
+
+	var ID uuid.UUID
+	if op != services.BatchOpCreate {
+		if ID, err = uuid.Parse(req.ID); err != nil {
+			return nil, &requestError{"invalid UUID", err}
+		}
+	}
+
+	batchOp := &services.BatchOperation{Op: op, Service: &services.Service{ID: ID}}
+	switch op {
+	case services.BatchOpCreate:
+		var createReq CreateRequest
+		if err := json.Unmarshal(req.Service, &createReq); err != nil {
+			return nil, &requestError{"invalid request body", err}
+		}
+		srv, reqErr := createReq.service()
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		batchOp.Service = srv
+	case services.BatchOpUpdate:
+		if err := checkMergePatch(req.Service); err != nil {
+			return nil, &requestError{"invalid request body", err}
+		}
+		batchOp.Patch = func(srv *services.Service) error {
+			return applyMergePatch(srv, req.Service)
+		}
+	}
+
+	return batchOp, nil
+}
+
+// ApplyBatch godoc
+// @Summary      Create, update and delete services in a batch
+// @Description  Applies up to 100 operations in order. In atomic mode, the default, either all of them are applied or none: when one fails the others get status 424. In best_effort mode every operation that succeeds on its own is applied.
+// @Description  Every operation gets the status and error the single create, update or delete route would answer with. The batch answers 200 when all the operations succeeded and 207 otherwise.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        request  body  BatchRequest  true  "Mode and operations"
+// @Success      200  {object}  BatchResponse   "All the operations succeeded"
+// @Success      207  {object}  BatchResponse   "Some of the operations failed"
+// @Failure      400  {object}  problem.Problem  "Invalid request body, mode or too many operations"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem  "API key without the scope of the route"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/batch [post]
+func (h *SubscriptionHandler) ApplyBatch(c *gin.Context) {
+	var req BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return
+	}
+
+	mode, err := services.ParseBatchMode(req.Mode)
+	if err == nil && len(req.Operations) > services.MaxBatchSize {
+		err = errors.New("too many operations")
+	}
+	if err != nil {
+		problem.Write(c, &requestError{"invalid batch", err})
+		return
+	}
+
+	results := make([]*BatchItemResult, len(req.Operations))
+	var ops []*services.BatchOperation
+	var indices []int
+	invalid := false
+	for i := range req.Operations {
+		results[i] = &BatchItemResult{Index: i, Op: req.Operations[i].Op}
+
+		op, reqErr := batchOperation(&req.Operations[i])
+		if reqErr != nil {
+			results[i].fail(reqErr)
+			invalid = true
+			continue
+		}
+		ops = append(ops, op)
+		indices = append(indices, i)
+	}
+
+	if invalid && mode == services.BatchAtomic {
+		for _, i := range indices {
+			results[i].fail(services.ErrBatchAborted)
+		}
+		ops = nil
+	}
+
+	var errs []error
+	if len(ops) > 0 {
+		errs, err = h.subscriptionService.ApplyBatch(c, ops, mode)
+	}
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	status := http.StatusOK
+	if invalid {
+		status = http.StatusMultiStatus
+	}
+	for j, err := range errs {
+		result, op := results[indices[j]], ops[j]
+		if err != nil {
+			result.fail(err)
+			status = http.StatusMultiStatus
+			if result.Status >= http.StatusInternalServerError {
+				logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+					"path":    c.Request.URL.Path,
+					"index":   result.Index,
+					"details": err.Error(),
+				}).Error("Batch operation failed")
+			}
+			continue
+		}
+
+		switch op.Op {
+		case services.BatchOpCreate:
+			result.Status, result.Service = http.StatusCreated, op.Service
+		case services.BatchOpUpdate:
+			result.Status, result.Service = http.StatusOK, op.Service
+		case services.BatchOpDelete:
+			result.Status = http.StatusNoContent
+		}
+	}
+
+	if status != http.StatusOK {
+		logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"path": c.Request.URL.Path,
+			"mode": mode,
+		}).Warn("Batch partially failed")
+	}
+	c.JSON(status, BatchResponse{Mode: mode, Results: results})
+}
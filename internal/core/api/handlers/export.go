@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportFlushRows is how many rows are buffered before they are sent on.
+const exportFlushRows = 500
+
+// exportColumns make an export readable by the CSV import.
+var exportColumns = []string{
+	"id", "service_name", "price", "currency", "user_id",
+	"start_date", "end_date", "billing_period", "billing_interval", "deleted_at",
+}
+
+func exportRecord(srv *services.Service) []string {
+	record := []string{
+		srv.ID.String(),
+		srv.ServiceName,
+		srv.Price.String(),
+		srv.Price.Currency,
+		srv.UserID.String(),
+		srv.StartDate.Format(time.DateOnly),
+		"",
+		string(srv.BillingPeriod),
+		strconv.Itoa(srv.BillingInterval),
+		"",
+	}
+	if srv.EndDate != nil {
+		record[6] = srv.EndDate.Format(time.DateOnly)
+	}
+	if srv.DeletedAt != nil {
+		record[9] = srv.DeletedAt.Format(time.RFC3339)
+	}
+	return record
+}
+
+// serviceWriter writes an export; close completes it and discard drops an
+// export that failed.
+type serviceWriter interface {
+	write(srv *services.Service) error
+	close() error
+	discard()
+}
+
+type exportFormat struct {
+	contentType string
+	newWriter   func(w io.Writer) (serviceWriter, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"csv":    {"text/csv; charset=utf-8", newCSVServiceWriter},
+	"ndjson": {"application/x-ndjson", newNDJSONServiceWriter},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXServiceWriter},
+}
+
+// flush sends the buffered rows on to the client.
+func flush(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+type csvServiceWriter struct {
+	out  io.Writer
+	w    *csv.Writer
+	rows int
+}
+
+func newCSVServiceWriter(out io.Writer) (serviceWriter, error) {
+	w := csv.NewWriter(out)
+	if err := w.Write(exportColumns); err != nil {
+		return nil, err
+	}
+	return &csvServiceWriter{out: out, w: w}, nil
+}
+
+func (sw *csvServiceWriter) write(srv *services.Service) error {
+	if err := sw.w.Write(exportRecord(srv)); err != nil {
+		return err
+	}
+	if sw.rows++; sw.rows%exportFlushRows == 0 {
+		sw.w.Flush()
+		flush(sw.out)
+	}
+	return sw.w.Error()
+}
+
+func (sw *csvServiceWriter) close() error {
+	sw.w.Flush()
+	return sw.w.Error()
+}
+
+func (sw *csvServiceWriter) discard() {}
+
+type ndjsonServiceWriter struct {
+	out  io.Writer
+	buf  *bufio.Writer
+	enc  *json.Encoder
+	rows int
+}
+
+func newNDJSONServiceWriter(out io.Writer) (serviceWriter, error) {
+	buf := bufio.NewWriter(out)
+	return &ndjsonServiceWriter{out: out, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (sw *ndjsonServiceWriter) write(srv *services.Service) error {
+	if err := sw.enc.Encode(srv); err != nil {
+		return err
+	}
+	if sw.rows++; sw.rows%exportFlushRows == 0 {
+		if err := sw.buf.Flush(); err != nil {
+			return err
+		}
+		flush(sw.out)
+	}
+	return nil
+}
+
+func (sw *ndjsonServiceWriter) close() error {
+	return sw.buf.Flush()
+}
+
+func (sw *ndjsonServiceWriter) discard() {}
+
+// xlsxServiceWriter builds the sheet with the excelize stream writer, which
+// keeps large sheets in a temporary file rather than in memory. The workbook
+// is only sent once complete.
+type xlsxServiceWriter struct {
+	out   io.Writer
+	file  *excelize.File
+	sheet *excelize.StreamWriter
+	row   int
+}
+
+func newXLSXServiceWriter(out io.Writer) (serviceWriter, error) {
+	file := excelize.NewFile()
+	sheet, err := file.NewStreamWriter("Sheet1")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	sw := &xlsxServiceWriter{out: out, file: file, sheet: sheet}
+	header := make([]any, len(exportColumns))
+	for i, column := range exportColumns {
+		header[i] = column
+	}
+	if err := sw.writeRow(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *xlsxServiceWriter) writeRow(values []any) error {
+	sw.row++
+	cell, err := excelize.CoordinatesToCellName(1, sw.row)
+	if err != nil {
+		return err
+	}
+	return sw.sheet.SetRow(cell, values)
+}
+
+func (sw *xlsxServiceWriter) write(srv *services.Service) error {
+	record := exportRecord(srv)
+	values := make([]any, len(record))
+	for i, value := range record {
+		values[i] = value
+	}
+	// Prices and intervals are numbers the spreadsheet can sum.
+	if price, err := strconv.ParseFloat(record[2], 64); err == nil {
+		values[2] = price
+	}
+	values[8] = srv.BillingInterval
+	return sw.writeRow(values)
+}
+
+func (sw *xlsxServiceWriter) close() error {
+	defer sw.file.Close()
+	if err := sw.sheet.Flush(); err != nil {
+		return err
+	}
+	return sw.file.Write(sw.out)
+}
+
+func (sw *xlsxServiceWriter) discard() {
+	sw.file.Close()
+}
+
+// ExportServices godoc
+// @Summary      Export services
+// @Description  Streams every service the list filters select, in the list order, as CSV, NDJSON or XLSX. The CSV columns are the ones the import reads; dates are YYYY-MM-DD.
+// @Tags         services
+// @Produce      text/csv
+// @Produce      application/x-ndjson
+// @Produce      application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param        format               query  string  false  "Export format, csv by default" Enums(csv, ndjson, xlsx)
+// @Param        user_id              query  string  false  "Only services of the user (admins only, others always see their own)"
+// @Param        service_name         query  string  false  "Exact service name"
+// @Param        service_name_prefix  query  string  false  "Case-insensitive service name prefix"
+// @Param        min_price            query  string  false  "Minimum current price" example:"100.00"
+// @Param        max_price            query  string  false  "Maximum current price" example:"999.90"
+// @Param        price_currency       query  string  false  "Currency of the price range, RUB by default"
+// @Param        active_at            query  string  false  "Only services active in the month, MM-YYYY or a YYYY-MM-DD day in it" example:"03-2025"
+// @Param        include_deleted      query  bool    false  "Also export deleted services"
+// @Param        sort                 query  string  false  "Comma separated service_name, price, start_date, end_date or user_id, descending with a leading -" example:"price,-start_date"
+// @Success      200  {file}    file            "Services in the requested format"
+// @Failure      400  {object}  problem.Problem  "Invalid format, filter or sort parameters"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem  "API key without the scope of the route"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/export [get]
+func (h *SubscriptionHandler) ExportServices(c *gin.Context) {
+	formatName := c.DefaultQuery("format", "csv")
+	format, ok := exportFormats[formatName]
+	if !ok {
+		problem.Write(c, &requestError{"invalid format", fmt.Errorf("unknown format %q", formatName)})
+		return
+	}
+
+	query, ok := bindListQuery(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", format.contentType)
+	c.Header("Content-Disposition", `attachment; filename="services.`+formatName+`"`)
+
+	w, err := format.newWriter(c.Writer)
+	if err == nil {
+		if err = h.subscriptionService.ExportServices(c, query, w.write); err == nil {
+			err = w.close()
+		} else {
+			w.discard()
+		}
+	}
+	if err == nil {
+		return
+	}
+
+	if c.Writer.Written() {
+		// The status is sent already; the client sees a truncated export.
+		logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"path":    c.Request.URL.Path,
+			"details": err.Error(),
+		}).Warn("Export interrupted")
+		return
+	}
+
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	problem.Write(c, err)
+}
@@ -3,25 +3,49 @@ package handlers
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
 	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
 )
 
 const dateLayout = "01-2006"
 
+// parseDate accepts dateLayout and, as spreadsheets tend to write dates, ISO
+// 8601 dates.
+func parseDate(s string) (time.Time, error) {
+	if date, err := time.Parse(dateLayout, s); err == nil {
+		return date, nil
+	}
+	if date, err := time.Parse(time.DateOnly, s); err == nil {
+		return date, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither MM-YYYY nor YYYY-MM-DD", s)
+}
+
 type SubscriptionService interface {
 	CreateService(ctx context.Context, srv *services.Service) error
 	GetService(ctx context.Context, ID uuid.UUID) (*services.Service, error)
-	GetServices(ctx context.Context, page, size int) ([]*services.Service, int, error)
+	GetServices(ctx context.Context, query *services.ListQuery) (*services.ServicePage, error)
 	UpdateService(ctx context.Context, srv *services.Service) error
-	DeleteService(ctx context.Context, ID uuid.UUID) error
-	CumulateServices(ctx context.Context, filters *services.Filters) (int, error)
+	PatchService(ctx context.Context, ID uuid.UUID, version int64, patch func(srv *services.Service) error) (*services.Service, error)
+	DeleteService(ctx context.Context, ID uuid.UUID, version int64) error
+	RestoreService(ctx context.Context, ID uuid.UUID) (*services.Service, error)
+	PurgeDeletedServices(ctx context.Context, olderThan time.Duration) (int64, error)
+	CumulateServices(ctx context.Context, filters *services.Filters) (services.Money, error)
+	CumulateServicesBreakdown(ctx context.Context, filters *services.Filters) ([]*services.MonthlyCost, error)
+	SchedulePriceChange(ctx context.Context, change *services.PriceChange) error
+	GetServicePrices(ctx context.Context, ID uuid.UUID) ([]*services.PriceChange, error)
+	GetServiceHistory(ctx context.Context, ID uuid.UUID) ([]*services.ServiceAuditEntry, error)
+	ApplyBatch(ctx context.Context, ops []*services.BatchOperation, mode services.BatchMode) ([]error, error)
+	ImportServices(ctx context.Context, srvs []*services.Service, commit bool) ([]error, error)
+	ExportServices(ctx context.Context, query *services.ListQuery, fn func(srv *services.Service) error) error
 }
 
 type SubscriptionHandler struct {
@@ -35,45 +59,41 @@ func NewSubscriptionHandler(ss SubscriptionService) *SubscriptionHandler {
 }
 
 type CreateRequest struct {
-	ServiceName string    `json:"service_name" example:"My Service"`
-	Price       int       `json:"price" example:"500"`
-	UserID      uuid.UUID `json:"user_id" example:"123e4567-e89b-12d3-a456-426614174000"`
-	StartDate   string    `json:"start_date" example:"01-2024"`
-	EndDate     *string   `json:"end_date,omitempty" example:"12-2025"`
+	ServiceName string         `json:"service_name" example:"My Service"`
+	Price       services.Money `json:"price"`
+	UserID      uuid.UUID      `json:"user_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
+	StartDate   string         `json:"start_date" example:"01-2024"`
+	EndDate     *string        `json:"end_date,omitempty" example:"12-2025"`
+
+	BillingPeriod   *string `json:"billing_period,omitempty" example:"month" enums:"week,month,quarter,year"`
+	BillingInterval *int    `json:"billing_interval,omitempty" example:"1"`
 }
 
-// @BasePath /service
+// requestError is a malformed part of a request. It wraps
+// services.ErrInvalidRequest.
+type requestError struct {
+	message string
+	err     error
+}
 
-// CreateService godoc
-// @Summary      Create a service
-// @Description  Create a service instance
-// @Tags         services
-// @Param    	 request  body  CreateRequest  true  "Create Service Request"
-// @Accept       json
-// @Produce      json
-// @Success      201  {object}  services.Service
-// @Failure      400  {object}  map[string]any "Invalid request body or date format"
-// @Failure      500  {object}  map[string]any "Internal server error"
-// @Router       / [post]
-func (h *SubscriptionHandler) CreateService(c *gin.Context) {
-	var req *CreateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid request body")
-		return
+func (e *requestError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() []error {
+	if e.err == nil {
+		return []error{services.ErrInvalidRequest}
 	}
+	return []error{services.ErrInvalidRequest, e.err}
+}
 
-	startDate, err := time.Parse(dateLayout, req.StartDate)
+func (req *CreateRequest) service() (*services.Service, *requestError) {
+	startDate, err := parseDate(req.StartDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start date", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("invalid start date")
-		return
+		return nil, &requestError{"invalid start date", err}
 	}
 
 	srv := &services.Service{
@@ -84,25 +104,89 @@ func (h *SubscriptionHandler) CreateService(c *gin.Context) {
 	}
 
 	if req.EndDate != nil {
-		endDate, err := time.Parse(dateLayout, *req.EndDate)
+		endDate, err := parseDate(*req.EndDate)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end date", "details": err.Error()})
-			logrus.WithFields(logrus.Fields{
-				"path":    c.Request.URL.Path,
-				"details": err.Error(),
-			}).Warn("invalid end date")
-			return
+			return nil, &requestError{"invalid end date", err}
 		}
 
 		srv.EndDate = &endDate
 	}
 
+	if req.BillingPeriod != nil {
+		billingPeriod, err := services.ParseBillingPeriod(*req.BillingPeriod)
+		if err != nil {
+			return nil, &requestError{"invalid billing period", err}
+		}
+
+		srv.BillingPeriod = billingPeriod
+	}
+
+	if req.BillingInterval != nil {
+		srv.BillingInterval = *req.BillingInterval
+	}
+
+	return srv, nil
+}
+
+// etag is the entity tag of the version of the service.
+func etag(srv *services.Service) string {
+	return `"` + strconv.FormatInt(srv.Version, 10) + `"`
+}
+
+// ifMatchVersion reads the version of the If-Match header, zero when the
+// header is missing or matches any version.
+func ifMatchVersion(c *gin.Context) (int64, *requestError) {
+	tag := strings.TrimSpace(c.GetHeader("If-Match"))
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+
+	unquoted, ok := strings.CutPrefix(tag, `"`)
+	if ok {
+		unquoted, ok = strings.CutSuffix(unquoted, `"`)
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if !ok || err != nil || version <= 0 {
+		return 0, &requestError{"invalid If-Match", fmt.Errorf("%s is not an entity tag of a service", tag)}
+	}
+	return version, nil
+}
+
+// @BasePath /
+
+// CreateService godoc
+// @Summary      Create a service
+// @Description  Create a service instance. Users other than admins can only create services for themselves, user_id defaults to the caller.
+// @Tags         services
+// @Param    	 request  body  CreateRequest  true  "Create Service Request"
+// @Param        Idempotency-Key  header  string  false  "Key to retry the request with: a repeated request gets the first response"
+// @Accept       json
+// @Produce      json
+// @Success      201  {object}  services.Service
+// @Failure      400  {object}  problem.Problem "Invalid request body or date format"
+// @Failure      401  {object}  problem.Problem "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem "Service for another user or API key without the scope"
+// @Failure      409  {object}  problem.Problem "Request with the idempotency key in progress"
+// @Failure      422  {object}  problem.Problem "Invalid fields or idempotency key reused with a different request"
+// @Failure      500  {object}  problem.Problem "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service [post]
+func (h *SubscriptionHandler) CreateService(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return
+	}
+
+	srv, reqErr := req.service()
+	if reqErr != nil {
+		problem.Write(c, reqErr)
+		return
+	}
+
 	if err := h.subscriptionService.CreateService(c, srv); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to add new service", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("failed to add new service")
+		problem.Write(c, err)
 		return
 	}
 
@@ -117,207 +201,556 @@ func (h *SubscriptionHandler) CreateService(c *gin.Context) {
 // @Produce      json
 // @Param        ID  path  string  true  "UUID of the service to retrieve" example:"123e4567-e89b-12d3-a456-426614174009"
 // @Success      200  {object}  services.Service  "Successfully retrieved service"
-// @Failure      400  {object}  map[string]any    "Invalid UUID"
-// @Failure      404  {object}  map[string]any    "Service not found"
-// @Failure      500  {object}  map[string]any    "Internal server error"
-// @Router       /{id} [get]
+// @Header       200  {string}  ETag              "Version of the service for If-Match"
+// @Failure      400  {object}  problem.Problem    "Invalid UUID"
+// @Failure      401  {object}  problem.Problem    "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem    "API key without the scope of the route"
+// @Failure      404  {object}  problem.Problem    "Service not found"
+// @Failure      500  {object}  problem.Problem    "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id} [get]
 func (h *SubscriptionHandler) GetService(c *gin.Context) {
-	ID, err := uuid.Parse(c.Param("ID"))
+	ID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID"})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid UUID")
+		problem.Write(c, &requestError{"invalid UUID", err})
 		return
 	}
 
 	srv, err := h.subscriptionService.GetService(c, ID)
 	if err != nil {
-		if errors.Is(err, services.ErrNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
-			logrus.WithFields(logrus.Fields{
-				"path":    c.Request.URL.Path,
-				"details": err.Error(),
-			}).Warn("Service not found")
-			return
-		}
-
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get service", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Failed to get service")
+		problem.Write(c, err)
 		return
 	}
 
+	c.Header("ETag", etag(srv))
 	c.JSON(http.StatusOK, srv)
 }
 
+type ListServicesRequest struct {
+	Page              int     `form:"page,default=1"`
+	Size              int     `form:"size,default=25"`
+	UserID            *string `form:"user_id"`
+	ServiceName       *string `form:"service_name"`
+	ServiceNamePrefix *string `form:"service_name_prefix"`
+	MinPrice          *string `form:"min_price"`
+	MaxPrice          *string `form:"max_price"`
+	PriceCurrency     *string `form:"price_currency"`
+	ActiveAt          *string `form:"active_at"`
+	IncludeDeleted    bool    `form:"include_deleted"`
+	Sort              string  `form:"sort"`
+	Pagination        string  `form:"pagination"`
+	Cursor            *string `form:"cursor"`
+	IncludeTotal      *bool   `form:"include_total"`
+}
+
+type ListServicesResponse struct {
+	Services   []*services.Service `json:"services"`
+	TotalCount *int                `json:"total_count,omitempty" example:"42"`
+	Page       int                 `json:"page,omitempty" example:"1"`
+	Size       int                 `json:"size" example:"25"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+	PrevCursor string              `json:"prev_cursor,omitempty"`
+}
+
+func bindListQuery(c *gin.Context) (*services.ListQuery, bool) {
+	invalid := func(message string, err error) (*services.ListQuery, bool) {
+		problem.Write(c, &requestError{message, err})
+		return nil, false
+	}
+
+	var req ListServicesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		return invalid("invalid query parameters", err)
+	}
+
+	if req.Page <= 0 || req.Size <= 0 {
+		problem.Write(c, &requestError{"invalid pagination parameters", nil})
+		return nil, false
+	}
+
+	query := &services.ListQuery{
+		ServiceName:       req.ServiceName,
+		ServiceNamePrefix: req.ServiceNamePrefix,
+		PriceCurrency:     services.DefaultCurrency,
+		IncludeDeleted:    req.IncludeDeleted,
+		Page:              req.Page,
+		Size:              req.Size,
+	}
+
+	var err error
+	if query.Sort, err = services.ParseSort(req.Sort); err != nil {
+		return invalid("invalid sort", err)
+	}
+
+	switch req.Pagination {
+	case "", "offset":
+	case "cursor":
+		query.Keyset = true
+	default:
+		return invalid("invalid pagination", fmt.Errorf("unknown mode %q", req.Pagination))
+	}
+	if req.Cursor != nil {
+		if req.Pagination == "offset" {
+			return invalid("invalid pagination", errors.New("cursor given in offset mode"))
+		}
+		if query.Cursor, err = services.ParseCursor(*req.Cursor); err != nil {
+			return invalid("invalid cursor", err)
+		}
+		query.Keyset = true
+	}
+	// Offset pages keep counting by default for the clients that number them.
+	query.IncludeTotal = !query.Keyset
+	if req.IncludeTotal != nil {
+		query.IncludeTotal = *req.IncludeTotal
+	}
+
+	if req.UserID != nil {
+		userID, err := uuid.Parse(*req.UserID)
+		if err != nil {
+			return invalid("invalid user_id", err)
+		}
+		query.UserID = &userID
+	}
+
+	if req.PriceCurrency != nil {
+		if query.PriceCurrency, err = services.ParseCurrency(*req.PriceCurrency); err != nil {
+			return invalid("invalid price_currency", err)
+		}
+	}
+
+	for _, bound := range []struct {
+		param string
+		value *string
+		dest  **int64
+	}{
+		{"min_price", req.MinPrice, &query.MinPrice},
+		{"max_price", req.MaxPrice, &query.MaxPrice},
+	} {
+		if bound.value == nil {
+			continue
+		}
+		price, err := services.ParseMoney(*bound.value, query.PriceCurrency)
+		if err != nil {
+			return invalid("invalid "+bound.param, err)
+		}
+		*bound.dest = &price.Amount
+	}
+
+	if req.ActiveAt != nil {
+		activeAt, err := parseDate(*req.ActiveAt)
+		if err != nil {
+			return invalid("invalid active_at", err)
+		}
+		// A day selects the month it falls in.
+		activeAt = time.Date(activeAt.Year(), activeAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+		query.ActiveAt = &activeAt
+	}
+
+	return query, true
+}
+
 // GetServices godoc
 // @Summary      Get all services
-// @Description  Retrieves a list of all service instances with pagination.
+// @Description  Retrieves a filtered and sorted list of the caller's service instances with pagination; admins see the services of every user. The price range applies to the current price in price_currency.
+// @Description  Pages are numbered by page, or with pagination=cursor follow each other through the opaque next_cursor and prev_cursor of the previous response. A cursor only fits the sort it was issued for.
 // @Tags         services
 // @Accept       json
 // @Produce      json
-// @Param        page    query      int   false  "Page number (starts from 1)"
-// @Param        size    query      int   false  "Number of items per page"
-// @Success      200  {array}  services.Service  "Successfully retrieved the list of services"
-// @Failure      400  {object}  map[string]any   "Invalid pagination parameters"
-// @Failure      500  {object}  map[string]any   "Internal server error"
-// @Router       / [get]
+// @Param        page                 query  int     false  "Page number (starts from 1)"
+// @Param        size                 query  int     false  "Number of items per page"
+// @Param        user_id              query  string  false  "Only services of the user (admins only, others always see their own)"
+// @Param        service_name         query  string  false  "Exact service name"
+// @Param        service_name_prefix  query  string  false  "Case-insensitive service name prefix"
+// @Param        min_price            query  string  false  "Minimum current price" example:"100.00"
+// @Param        max_price            query  string  false  "Maximum current price" example:"999.90"
+// @Param        price_currency       query  string  false  "Currency of the price range, RUB by default"
+// @Param        active_at            query  string  false  "Only services active in the month, MM-YYYY or a YYYY-MM-DD day in it" example:"03-2025"
+// @Param        include_deleted      query  bool    false  "Also list deleted services"
+// @Param        sort                 query  string  false  "Comma separated service_name, price, start_date, end_date or user_id, descending with a leading -" example:"price,-start_date"
+// @Param        pagination           query  string  false  "Pagination mode, offset by default" Enums(offset, cursor)
+// @Param        cursor               query  string  false  "next_cursor or prev_cursor of the previous page, implies pagination=cursor"
+// @Param        include_total        query  bool    false  "Count all the matching services, true by default in offset mode only"
+// @Success      200  {object}  ListServicesResponse  "Successfully retrieved the list of services"
+// @Failure      400  {object}  problem.Problem   "Invalid pagination, cursor, filter or sort parameters"
+// @Failure      401  {object}  problem.Problem   "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem   "API key without the scope of the route"
+// @Failure      500  {object}  problem.Problem   "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service [get]
 func (h *SubscriptionHandler) GetServices(c *gin.Context) {
-	page, errPage := strconv.Atoi(c.DefaultQuery("page", "1"))
-	size, errSize := strconv.Atoi(c.DefaultQuery("size", "25"))
-
-	if errPage != nil || errSize != nil || page <= 0 || size <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pagination parameters"})
-		logrus.WithFields(logrus.Fields{
-			"path": c.Request.URL.Path,
-		}).Warn("Invalid pagination parameters")
+	query, ok := bindListQuery(c)
+	if !ok {
 		return
 	}
 
-	srvs, totalCount, err := h.subscriptionService.GetServices(c, page, size)
+	page, err := h.subscriptionService.GetServices(c, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get services", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Failed to get services")
+		problem.Write(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"services":    srvs,
-		"total_count": totalCount,
-		"page":        page,
-		"size":        size,
-	})
+	resp := ListServicesResponse{
+		Services:   page.Services,
+		TotalCount: page.TotalCount,
+		Size:       query.Size,
+	}
+	if query.Keyset {
+		if page.NextCursor != nil {
+			resp.NextCursor = page.NextCursor.Encode()
+		}
+		if page.PrevCursor != nil {
+			resp.PrevCursor = page.PrevCursor.Encode()
+		}
+	} else {
+		resp.Page = query.Page
+	}
+
+	c.JSON(http.StatusOK, resp)
 }
 
+// UpdateRequest documents the JSON merge patch of a service: the fields it
+// holds replace those of the service, a null end_date clears it and a null
+// billing period or interval resets it to the default.
 type UpdateRequest struct {
-	ServiceName *string    `json:"service_name,omitempty" example:"My Service"`
-	Price       *int       `json:"price,omitempty" example:"500"`
-	UserID      *uuid.UUID `json:"user_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
-	StartDate   *time.Time `json:"start_date,omitempty" example:"01-2024"`
-	EndDate     *time.Time `json:"end_date,omitempty" example:"12-2025"`
+	ServiceName *string         `json:"service_name,omitempty" example:"My Service"`
+	Price       *services.Money `json:"price,omitempty"`
+	UserID      *uuid.UUID      `json:"user_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
+	StartDate   *string         `json:"start_date,omitempty" example:"01-2024"`
+	EndDate     *string         `json:"end_date,omitempty" example:"12-2025" extensions:"x-nullable"`
+
+	BillingPeriod   *string `json:"billing_period,omitempty" example:"month" enums:"week,month,quarter,year"`
+	BillingInterval *int    `json:"billing_interval,omitempty" example:"1"`
 }
 
 // UpdateService godoc
 // @Summary      Update an existing service
-// @Description  Updates an existing service instance by its name. The fields in the request body are optional and only the provided fields will be updated.
+// @Description  Applies a JSON merge patch (RFC 7396) to the service: only the fields in the body change, a null end_date reopens a cancelled service and a null billing_period or billing_interval resets it to the default. Dates are MM-YYYY or YYYY-MM-DD. A new price takes effect from the current month on. A new currency is only accepted when the new price replaces the only price of the service.
 // @Tags         services
 // @Accept       json
+// @Accept       application/merge-patch+json
 // @Produce      json
 // @Param        ID  		   path  string         true  "UUID of the service to update" example:"123e4567-e89b-12d3-a456-426614174009"
-// @Param        request       body  UpdateRequest  true  "Request update service with optional fields"
+// @Param        request       body  UpdateRequest  true  "Merge patch of the service"
+// @Param        If-Match      header  string       false  "ETag of the version to update"
 // @Success      200           {object} services.Service  "Successfully updated the service"
-// @Failure      400           {object} map[string]any    "Invalid UUID or request body or malformed data"
-// @Failure      404           {object} map[string]any    "Service not found"
-// @Failure      500           {object} map[string]any    "Internal server error"
-// @Router       /{id} [patch]
+// @Header       200           {string} ETag              "Version of the updated service"
+// @Failure      400           {object} problem.Problem    "Invalid UUID, If-Match or request body or malformed data"
+// @Failure      401           {object} problem.Problem    "Missing or invalid bearer token or API key"
+// @Failure      403           {object} problem.Problem    "Handing the service over to another user or API key without the scope"
+// @Failure      404           {object} problem.Problem    "Service not found"
+// @Failure      412           {object} problem.Problem    "The service is no longer at the If-Match version"
+// @Failure      422           {object} problem.Problem "The updated service has invalid fields"
+// @Failure      500           {object} problem.Problem    "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id} [patch]
 func (h *SubscriptionHandler) UpdateService(c *gin.Context) {
-	ID, err := uuid.Parse(c.Param("ID"))
+	ID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID"})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid UUID")
+		problem.Write(c, &requestError{"invalid UUID", err})
 		return
 	}
 
-	var req UpdateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid request body")
+	patch, err := c.GetRawData()
+	if err == nil {
+		err = checkMergePatch(patch)
+	}
+	if err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
 		return
 	}
 
-	updatedSrv := &services.Service{ID: ID}
-	if req.ServiceName != nil {
-		updatedSrv.ServiceName = *req.ServiceName
-	}
-	if req.Price != nil {
-		updatedSrv.Price = *req.Price
+	version, reqErr := ifMatchVersion(c)
+	if reqErr != nil {
+		problem.Write(c, reqErr)
+		return
 	}
-	if req.UserID != nil {
-		updatedSrv.UserID = *req.UserID
+
+	updatedSrv, err := h.subscriptionService.PatchService(c, ID, version, func(srv *services.Service) error {
+		return applyMergePatch(srv, patch)
+	})
+	if err != nil {
+		problem.Write(c, err)
+		return
 	}
-	if req.StartDate != nil {
-		updatedSrv.StartDate = *req.StartDate
+
+	c.Header("ETag", etag(updatedSrv))
+	c.JSON(http.StatusOK, updatedSrv)
+}
+
+// ReplaceService godoc
+// @Summary      Replace a service
+// @Description  Replaces every field of the service with the request, which is the one of the create route: the optional fields left out are cleared or reset to the default, a missing user_id keeps the owner. A new price takes effect from the current month on. A new currency is only accepted when the new price replaces the only price of the service.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        ID  		   path  string         true  "UUID of the service to replace" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Param        request       body  CreateRequest  true  "New state of the service"
+// @Param        If-Match      header  string       false  "ETag of the version to replace"
+// @Success      200           {object} services.Service  "Successfully replaced the service"
+// @Header       200           {string} ETag              "Version of the replaced service"
+// @Failure      400           {object} problem.Problem    "Invalid UUID, If-Match or request body or malformed data"
+// @Failure      401           {object} problem.Problem    "Missing or invalid bearer token or API key"
+// @Failure      403           {object} problem.Problem    "Handing the service over to another user or API key without the scope"
+// @Failure      404           {object} problem.Problem    "Service not found"
+// @Failure      412           {object} problem.Problem    "The service is no longer at the If-Match version"
+// @Failure      422           {object} problem.Problem "The service has invalid fields"
+// @Failure      500           {object} problem.Problem    "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id} [put]
+func (h *SubscriptionHandler) ReplaceService(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
 	}
-	if req.EndDate != nil {
-		updatedSrv.EndDate = req.EndDate
+
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return
 	}
 
-	if err := h.subscriptionService.UpdateService(c, updatedSrv); err != nil {
-		if errors.Is(err, services.ErrNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
-			logrus.WithFields(logrus.Fields{
-				"path":    c.Request.URL.Path,
-				"details": err.Error(),
-			}).Warn("Service not found")
-			return
-		}
+	srv, reqErr := req.service()
+	if reqErr == nil {
+		srv.Version, reqErr = ifMatchVersion(c)
+	}
+	if reqErr != nil {
+		problem.Write(c, reqErr)
+		return
+	}
+	srv.ID = ID
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update service", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Failed to update service")
+	if err := h.subscriptionService.UpdateService(c, srv); err != nil {
+		problem.Write(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, updatedSrv)
+	c.Header("ETag", etag(srv))
+	c.JSON(http.StatusOK, srv)
 }
 
 // DeleteService godoc
 // @Summary      Delete a service
-// @Description  Deletes a service instance by its name.
+// @Description  Marks a service instance deleted: it disappears from listings and cumulation but can be restored until it is purged. To stop a subscription set its end date instead.
 // @Tags         services
 // @Accept       json
 // @Produce      json
 // @Param        ID  		   path  string         true  "UUID of the service to delete" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Param        If-Match      header  string       false  "ETag of the version to delete"
 // @Success      204           "Successfully deleted the service"
-// @Failure      400           {object} map[string]any    "Invalid UUID"
-// @Failure      404           {object} map[string]any    "Service not found"
-// @Failure      500           {object} map[string]any    "Internal server error"
-// @Router       /{id} [delete]
+// @Failure      400           {object} problem.Problem    "Invalid UUID or If-Match"
+// @Failure      401           {object} problem.Problem    "Missing or invalid bearer token or API key"
+// @Failure      403           {object} problem.Problem    "API key without the scope of the route"
+// @Failure      404           {object} problem.Problem    "Service not found"
+// @Failure      412           {object} problem.Problem    "The service is no longer at the If-Match version"
+// @Failure      500           {object} problem.Problem    "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id} [delete]
 func (h *SubscriptionHandler) DeleteService(c *gin.Context) {
 	ID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid UUID"})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid UUID")
-		return
-	}
-
-	if err = h.subscriptionService.DeleteService(c, ID); err != nil {
-		if errors.Is(err, services.ErrNotFound) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "service not found"})
-			logrus.WithFields(logrus.Fields{
-				"path":    c.Request.URL.Path,
-				"details": err.Error(),
-			}).Warn("Service not found")
-			return
-		}
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	version, reqErr := ifMatchVersion(c)
+	if reqErr != nil {
+		problem.Write(c, reqErr)
+		return
+	}
+
+	if err = h.subscriptionService.DeleteService(c, ID, version); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RestoreService godoc
+// @Summary      Restore a deleted service
+// @Description  Brings a deleted service back into listings and cumulation.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the deleted service" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Success      200  {object}  services.Service  "Successfully restored the service"
+// @Failure      400  {object}  problem.Problem    "Invalid UUID"
+// @Failure      401  {object}  problem.Problem    "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem    "API key without the scope of the route"
+// @Failure      404  {object}  problem.Problem    "Deleted service not found"
+// @Failure      500  {object}  problem.Problem    "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id}/restore [post]
+func (h *SubscriptionHandler) RestoreService(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	srv, err := h.subscriptionService.RestoreService(c, ID)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, srv)
+}
+
+// PurgeDeletedServices godoc
+// @Summary      Purge deleted services
+// @Description  Permanently removes the services deleted more than the given number of days ago. Their audit history is kept.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        older_than_days  query  int  true  "Minimum age of the deletion in days" example:"30"
+// @Success      200  {object}  map[string]int  "Number of purged services"
+// @Failure      400  {object}  problem.Problem  "Invalid number of days"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can purge services"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /service/purge [post]
+func (h *SubscriptionHandler) PurgeDeletedServices(c *gin.Context) {
+	days, err := strconv.Atoi(c.Query("older_than_days"))
+	if err != nil || days < 0 {
+		problem.Write(c, &requestError{"invalid older_than_days", err})
+		return
+	}
+
+	purged, err := h.subscriptionService.PurgeDeletedServices(c, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+type PriceChangeRequest struct {
+	Price         services.Money `json:"price"`
+	EffectiveFrom string         `json:"effective_from" example:"01-2025"`
+}
+
+// SchedulePriceChange godoc
+// @Summary      Schedule a price change
+// @Description  Sets the price of a service from the given month on, MM-YYYY or YYYY-MM-DD, which must not be before the month the service starts. Cumulation uses the price in effect in each month, so past totals stay unchanged.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        ID  		   path  string              true  "UUID of the service" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Param        request       body  PriceChangeRequest  true  "New price and the month it takes effect"
+// @Success      201           {object} services.PriceChange  "Successfully scheduled the price change"
+// @Failure      400           {object} problem.Problem        "Invalid UUID or request body or date format"
+// @Failure      401           {object} problem.Problem        "Missing or invalid bearer token or API key"
+// @Failure      403           {object} problem.Problem        "API key without the scope of the route"
+// @Failure      404           {object} problem.Problem        "Service not found"
+// @Failure      422           {object} problem.Problem        "Negative price, a currency other than the service's or a month before its start"
+// @Failure      500           {object} problem.Problem        "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id}/prices [post]
+func (h *SubscriptionHandler) SchedulePriceChange(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	var req PriceChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return
+	}
+
+	effectiveFrom, err := parseDate(req.EffectiveFrom)
+	if err != nil {
+		problem.Write(c, &requestError{"invalid effective from date", err})
+		return
+	}
 
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update service", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Failed to update service")
+	change := &services.PriceChange{
+		ServiceID:     ID,
+		EffectiveFrom: effectiveFrom,
+		Price:         req.Price,
+	}
+	if err := h.subscriptionService.SchedulePriceChange(c, change); err != nil {
+		problem.Write(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, nil)
+	c.JSON(http.StatusCreated, change)
+}
+
+// GetServicePrices godoc
+// @Summary      Get the price history of a service
+// @Description  Lists the prices of a service with the months they take effect, starting with the price at the start date.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the service" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Success      200  {array}   services.PriceChange  "Successfully retrieved the price history"
+// @Failure      400  {object}  problem.Problem        "Invalid UUID"
+// @Failure      401  {object}  problem.Problem        "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem        "API key without the scope of the route"
+// @Failure      404  {object}  problem.Problem        "Service not found"
+// @Failure      500  {object}  problem.Problem        "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id}/prices [get]
+func (h *SubscriptionHandler) GetServicePrices(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	prices, err := h.subscriptionService.GetServicePrices(c, ID)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prices)
+}
+
+// GetServiceHistory godoc
+// @Summary      Get the audit history of a service
+// @Description  Lists every creation, update, price change and deletion of a service with the actor and the stored state before and after it, oldest first. Deleted services keep their history.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the service" example:"123e4567-e89b-12d3-a456-426614174009"
+// @Success      200  {array}   services.ServiceAuditEntry  "Successfully retrieved the history"
+// @Failure      400  {object}  problem.Problem              "Invalid UUID"
+// @Failure      401  {object}  problem.Problem              "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem              "API key without the scope of the route"
+// @Failure      404  {object}  problem.Problem              "Service not found"
+// @Failure      500  {object}  problem.Problem              "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/{id}/history [get]
+func (h *SubscriptionHandler) GetServiceHistory(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	history, err := h.subscriptionService.GetServiceHistory(c, ID)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
 }
 
 type CumulateFiltersRequest struct {
@@ -325,48 +758,27 @@ type CumulateFiltersRequest struct {
 	UserIDs      []*uuid.UUID `json:"user_id,omitempty" example:"[\"123e4567-e89b-12d3-a456-426614174000\"]"`
 	StartDate    string       `json:"start_date" example:"01-2024"`
 	EndDate      string       `json:"end_date" example:"12-2025"`
+
+	TargetCurrency *string `json:"target_currency,omitempty" example:"RUB"`
 }
 
-// CumulateServices godoc
-// @Summary      Cumulate service costs
-// @Description  Calculates the total cost of services based on provided filters for date range, user ID and service name.
-// @Tags         services
-// @Accept       json
-// @Produce      json
-// @Param        filters  body  services.Filters  true  "Filters for cumulation, including date range and optional users ID and service names"
-// @Success      200      {number}  float64       	 "Successfully calculated the total cost"
-// @Failure      400      {object}  map[string]any   "Invalid request body or filters"
-// @Failure      500      {object}  map[string]any   "Internal server error"
-// @Router       /cummulate [get]
-func (h *SubscriptionHandler) CumulateServices(c *gin.Context) {
+func bindCumulateFilters(c *gin.Context) (*services.Filters, bool) {
 	var filtersReq CumulateFiltersRequest
 	if err := c.ShouldBindJSON(&filtersReq); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid request body")
-		return
+		problem.Write(c, &requestError{"invalid request body", err})
+		return nil, false
 	}
 
-	startDate, err := time.Parse(dateLayout, filtersReq.StartDate)
+	startDate, err := parseDate(filtersReq.StartDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid start date", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid start date")
-		return
+		problem.Write(c, &requestError{"invalid start date", err})
+		return nil, false
 	}
 
-	endDate, err := time.Parse(dateLayout, filtersReq.EndDate)
+	endDate, err := parseDate(filtersReq.EndDate)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid end date", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Invalid end date")
-		return
+		problem.Write(c, &requestError{"invalid end date", err})
+		return nil, false
 	}
 
 	filters := &services.Filters{
@@ -376,15 +788,77 @@ func (h *SubscriptionHandler) CumulateServices(c *gin.Context) {
 		EndDate:   endDate,
 	}
 
+	if filtersReq.TargetCurrency != nil {
+		filters.TargetCurrency, err = services.ParseCurrency(*filtersReq.TargetCurrency)
+		if err != nil {
+			problem.Write(c, &requestError{"invalid target currency", err})
+			return nil, false
+		}
+	}
+
+	return filters, true
+}
+
+// CumulateServices godoc
+// @Summary      Cumulate service costs
+// @Description  Calculates the total cost of services based on provided filters for date range, user ID and service name. Users other than admins only cumulate their own services. Costs in other currencies are converted to the target currency (RUB by default) at the rate in effect in each month.
+// @Description  The window dates are MM-YYYY or YYYY-MM-DD: the window may start and end inside a month.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        filters  body  CumulateFiltersRequest  true  "Filters for cumulation, including date range and optional users ID, service names and target currency"
+// @Success      200      {object}  services.Money   "Successfully calculated the total cost"
+// @Failure      400      {object}  problem.Problem   "Invalid request body or filters"
+// @Failure      401      {object}  problem.Problem   "Missing or invalid bearer token or API key"
+// @Failure      403      {object}  problem.Problem   "API key without the scope of the route"
+// @Failure      422      {object}  problem.Problem   "Inverted date range, missing exchange rate for conversion or the total overflows"
+// @Failure      500      {object}  problem.Problem   "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/cumulate [get]
+func (h *SubscriptionHandler) CumulateServices(c *gin.Context) {
+	filters, ok := bindCumulateFilters(c)
+	if !ok {
+		return
+	}
+
 	sum, err := h.subscriptionService.CumulateServices(c, filters)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to cumulate price", "details": err.Error()})
-		logrus.WithFields(logrus.Fields{
-			"path":    c.Request.URL.Path,
-			"details": err.Error(),
-		}).Warn("Failed to cumulate price")
+		problem.Write(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, sum)
 }
+
+// CumulateServicesBreakdown godoc
+// @Summary      Monthly breakdown of service costs
+// @Description  Splits the cumulated cost into one entry per month of the date range, with subtotals per service name and per user. Month totals add up to the cumulate result for the same filters.
+// @Description  The window dates are MM-YYYY or YYYY-MM-DD: a month the window only partly covers counts the charges inside the window.
+// @Tags         services
+// @Accept       json
+// @Produce      json
+// @Param        filters  body  CumulateFiltersRequest  true  "Filters for cumulation, including date range and optional users ID and service names"
+// @Success      200      {array}   services.MonthlyCost  "Successfully calculated the monthly breakdown"
+// @Failure      400      {object}  problem.Problem        "Invalid request body or filters"
+// @Failure      401      {object}  problem.Problem        "Missing or invalid bearer token or API key"
+// @Failure      403      {object}  problem.Problem        "API key without the scope of the route"
+// @Failure      422      {object}  problem.Problem        "Inverted date range, missing exchange rate for conversion or a total overflows"
+// @Failure      500      {object}  problem.Problem        "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/cumulate/breakdown [get]
+func (h *SubscriptionHandler) CumulateServicesBreakdown(c *gin.Context) {
+	filters, ok := bindCumulateFilters(c)
+	if !ok {
+		return
+	}
+
+	breakdown, err := h.subscriptionService.CumulateServicesBreakdown(c, filters)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, breakdown)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// deletingService deletes the services at version 3 only.
+type deletingService struct {
+	SubscriptionService
+	deleted []uuid.UUID
+}
+
+func (s *deletingService) DeleteService(_ context.Context, ID uuid.UUID, version int64) error {
+	if version != 0 && version != 3 {
+		return services.ErrVersionMismatch
+	}
+	s.deleted = append(s.deleted, ID)
+	return nil
+}
+
+func TestDeleteService(t *testing.T) {
+	ID := uuid.New()
+
+	tests := []struct {
+		name     string
+		path     string
+		ifMatch  string
+		wantCode int
+	}{
+		{"deleted", "/service/" + ID.String(), "", http.StatusNoContent},
+		{"at the version", "/service/" + ID.String(), `"3"`, http.StatusNoContent},
+		{"stale version", "/service/" + ID.String(), `"2"`, http.StatusPreconditionFailed},
+		{"invalid If-Match", "/service/" + ID.String(), "3", http.StatusBadRequest},
+		{"invalid UUID", "/service/42", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srvService := &deletingService{}
+			router := gin.New()
+			router.DELETE("/service/:id", NewSubscriptionHandler(srvService).DeleteService)
+
+			req := httptest.NewRequest(http.MethodDelete, tt.path, nil)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode != http.StatusNoContent {
+				return
+			}
+			if rec.Body.Len() != 0 {
+				t.Errorf("204 with a body: %s", rec.Body)
+			}
+			if len(srvService.deleted) != 1 || srvService.deleted[0] != ID {
+				t.Errorf("deleted %v, want %s", srvService.deleted, ID)
+			}
+		})
+	}
+}
+
+func TestBindCumulateFiltersParsesDates(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantStart time.Time
+		wantEnd   time.Time
+		wantCode  int
+	}{
+		{"months", `{"start_date": "01-2024", "end_date": "03-2024"}`, time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC), http.StatusOK},
+		{"days", `{"start_date": "2024-01-10", "end_date": "2024-03-20"}`, time.Date(2024, time.January, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 20, 0, 0, 0, 0, time.UTC), http.StatusOK},
+		{"invalid start date", `{"start_date": "2024/01/10", "end_date": "03-2024"}`, time.Time{}, time.Time{}, http.StatusBadRequest},
+		{"invalid end date", `{"start_date": "01-2024", "end_date": "20.03.2024"}`, time.Time{}, time.Time{}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPost, "/service/cumulate", strings.NewReader(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			filters, ok := bindCumulateFilters(c)
+			if !ok {
+				if tt.wantCode == http.StatusOK {
+					t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+				}
+				if rec.Code != tt.wantCode {
+					t.Errorf("got status %d, want %d", rec.Code, tt.wantCode)
+				}
+				return
+			}
+			if tt.wantCode != http.StatusOK {
+				t.Fatalf("bound %+v, want status %d", filters, tt.wantCode)
+			}
+			if !filters.StartDate.Equal(tt.wantStart) || !filters.EndDate.Equal(tt.wantEnd) {
+				t.Errorf("got window %s to %s, want %s to %s", filters.StartDate, filters.EndDate, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/gin-gonic/gin"
+)
+
+type ReadinessProbe interface {
+	Ready(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	readiness ReadinessProbe
+}
+
+func NewHealthHandler(rp ReadinessProbe) *HealthHandler {
+	return &HealthHandler{
+		readiness: rp,
+	}
+}
+
+type HealthResponse struct {
+	Status string `json:"status" example:"ok"`
+}
+
+// Healthz godoc
+// @Summary      Liveness probe
+// @Description  Answers as long as the process serves HTTP; it checks no dependencies.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthResponse
+// @Router       /healthz [get]
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz godoc
+// @Summary      Readiness probe
+// @Description  Succeeds while the database answers, its migrations are applied and the service is not shutting down. The reason of a failure is logged, not returned.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  HealthResponse
+// @Failure      503  {object}  problem.Problem  "Not ready to take traffic"
+// @Router       /readyz [get]
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if err := h.readiness.Ready(c.Request.Context()); err != nil {
+		problem.WriteProblem(c, problem.New(http.StatusServiceUnavailable, "not-ready", "Not ready", ""), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, HealthResponse{Status: "ready"})
+}
@@ -0,0 +1,344 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const maxImportFileSize = 10 << 20
+
+// importFields are the service fields a CSV column can be mapped to.
+var importFields = []string{
+	"service_name", "price", "currency", "user_id",
+	"start_date", "end_date", "billing_period", "billing_interval",
+}
+
+var requiredImportFields = []string{"service_name", "price", "start_date"}
+
+type ImportRequest struct {
+	File      *multipart.FileHeader `form:"file" binding:"required" swaggerignore:"true"`
+	Mode      string                `form:"mode"`
+	Mapping   string                `form:"mapping"`
+	Delimiter string                `form:"delimiter"`
+}
+
+// ImportRowError is a problem of a CSV row; Row counts the lines of the file
+// from 1, the header included.
+type ImportRowError struct {
+	Row     int    `json:"row" example:"2"`
+	Column  string `json:"column,omitempty" example:"start_date"`
+	Error   string `json:"error" example:"invalid start date"`
+	Details string `json:"details,omitempty"`
+}
+
+type ImportResponse struct {
+	Mode     string              `json:"mode" example:"dry_run"`
+	Rows     int                 `json:"rows" example:"12"`
+	Valid    int                 `json:"valid" example:"11"`
+	Errors   []*ImportRowError   `json:"errors"`
+	Services []*services.Service `json:"services,omitempty"`
+}
+
+// importColumns maps the import fields to the indices of their CSV columns.
+type importColumns map[string]int
+
+// newImportColumns finds the columns of the fields in the header; mapping
+// names the column of a field when it is not named after the field.
+func newImportColumns(header []string, mapping map[string]string) (importColumns, error) {
+	indices := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		indices[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	columns := make(importColumns)
+	for _, field := range importFields {
+		name := field
+		if mapped, ok := mapping[field]; ok {
+			name = mapped
+		}
+		if i, ok := indices[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[field] = i
+		}
+	}
+
+	var missing []string
+	for _, field := range requiredImportFields {
+		if _, ok := columns[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("no column for %s", strings.Join(missing, ", "))
+	}
+
+	return columns, nil
+}
+
+func (cols importColumns) value(record []string, field string) string {
+	i, ok := cols[field]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// service parses a record into a service, reporting every malformed field.
+func (cols importColumns) service(row int, record []string) (*services.Service, []*ImportRowError) {
+	var rowErrs []*ImportRowError
+	fail := func(column, message string, err error) {
+		rowErr := &ImportRowError{Row: row, Column: column, Error: message}
+		if err != nil {
+			rowErr.Details = err.Error()
+		}
+		rowErrs = append(rowErrs, rowErr)
+	}
+
+	srv := &services.Service{ServiceName: cols.value(record, "service_name")}
+
+	currency := services.DefaultCurrency
+	if value := cols.value(record, "currency"); value != "" {
+		var err error
+		if currency, err = services.ParseCurrency(value); err != nil {
+			fail("currency", "invalid currency", err)
+		}
+	}
+
+	// Spreadsheets in Russian locales write a decimal comma.
+	price, err := services.ParseMoney(strings.Replace(cols.value(record, "price"), ",", ".", 1), currency)
+	if err != nil {
+		fail("price", "invalid price", err)
+	}
+	srv.Price = price
+
+	if value := cols.value(record, "user_id"); value != "" {
+		if srv.UserID, err = uuid.Parse(value); err != nil {
+			fail("user_id", "invalid user_id", err)
+		}
+	}
+
+	if srv.StartDate, err = parseDate(cols.value(record, "start_date")); err != nil {
+		fail("start_date", "invalid start date", err)
+	}
+
+	if value := cols.value(record, "end_date"); value != "" {
+		endDate, err := parseDate(value)
+		if err != nil {
+			fail("end_date", "invalid end date", err)
+		}
+		srv.EndDate = &endDate
+	}
+
+	if value := cols.value(record, "billing_period"); value != "" {
+		if srv.BillingPeriod, err = services.ParseBillingPeriod(value); err != nil {
+			fail("billing_period", "invalid billing period", err)
+		}
+	}
+
+	if value := cols.value(record, "billing_interval"); value != "" {
+		if srv.BillingInterval, err = strconv.Atoi(value); err != nil {
+			fail("billing_interval", "invalid billing interval", err)
+		}
+	}
+
+	return srv, rowErrs
+}
+
+// ImportServices godoc
+// @Summary      Import services from CSV
+// @Description  Reads services from a CSV file with a header row and validates every row. Dates are MM-YYYY or YYYY-MM-DD, prices are decimals in the currency of the row, RUB by default.
+// @Description  In dry_run mode, the default, only the error report is returned. In commit mode all the services are created in one transaction, and none of them when any row is invalid.
+// @Tags         services
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        file       formData  file    true   "CSV file"
+// @Param        mode       formData  string  false  "dry_run or commit" Enums(dry_run, commit)
+// @Param        mapping    formData  string  false  "JSON object naming the column of each field not named after it: service_name, price, currency, user_id, start_date, end_date, billing_period, billing_interval" example:"{\"service_name\":\"Сервис\",\"price\":\"Цена\"}"
+// @Param        delimiter  formData  string  false  "Field delimiter, a comma by default" example:";"
+// @Success      200  {object}  ImportResponse  "Dry run report"
+// @Success      201  {object}  ImportResponse  "All the services were created"
+// @Failure      400  {object}  problem.Problem  "Invalid form, mapping, delimiter or malformed CSV"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem  "API key without the scope of the route"
+// @Failure      413  {object}  problem.Problem  "File too large"
+// @Failure      422  {object}  ImportResponse  "Invalid rows, nothing was created"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /service/import [post]
+func (h *SubscriptionHandler) ImportServices(c *gin.Context) {
+	invalid := func(message string, err error) {
+		problem.Write(c, &requestError{message, err})
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileSize)
+
+	var req ImportRequest
+	if err := c.ShouldBind(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.WriteProblem(c, problem.New(http.StatusRequestEntityTooLarge, "too-large", "File too large", tooLarge.Error()), err)
+			return
+		}
+		invalid("invalid form", err)
+		return
+	}
+
+	commit := false
+	switch req.Mode {
+	case "", "dry_run":
+	case "commit":
+		commit = true
+	default:
+		invalid("invalid mode", fmt.Errorf("unknown mode %q", req.Mode))
+		return
+	}
+
+	mapping := make(map[string]string)
+	if req.Mapping != "" {
+		if err := json.Unmarshal([]byte(req.Mapping), &mapping); err != nil {
+			invalid("invalid mapping", err)
+			return
+		}
+		for field := range mapping {
+			if !slices.Contains(importFields, field) {
+				invalid("invalid mapping", fmt.Errorf("unknown field %q", field))
+				return
+			}
+		}
+	}
+
+	delimiter := ','
+	if req.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(req.Delimiter)
+		if size != len(req.Delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			invalid("invalid delimiter", fmt.Errorf("%q is not a single character", req.Delimiter))
+			return
+		}
+		delimiter = r
+	}
+
+	file, err := req.File.Open()
+	if err != nil {
+		invalid("invalid file", err)
+		return
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		invalid("invalid CSV", err)
+		return
+	}
+	columns, err := newImportColumns(header, mapping)
+	if err != nil {
+		invalid("invalid CSV header", err)
+		return
+	}
+
+	resp := ImportResponse{Mode: "dry_run", Errors: []*ImportRowError{}}
+	if commit {
+		resp.Mode = "commit"
+	}
+
+	var srvs []*services.Service
+	var rows []int
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			invalid("invalid CSV", err)
+			return
+		}
+		resp.Rows++
+		if resp.Rows > services.MaxImportRows {
+			invalid("invalid CSV", fmt.Errorf("more than %d rows", services.MaxImportRows))
+			return
+		}
+
+		row, _ := reader.FieldPos(0)
+		srv, rowErrs := columns.service(row, record)
+		if len(rowErrs) > 0 {
+			resp.Errors = append(resp.Errors, rowErrs...)
+			continue
+		}
+		srvs = append(srvs, srv)
+		rows = append(rows, row)
+	}
+
+	errs, err := h.subscriptionService.ImportServices(c, srvs, commit && len(resp.Errors) == 0)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		var validationErr *services.ValidationError
+		if errors.As(err, &validationErr) {
+			for _, f := range validationErr.Fields {
+				column := f.Field
+				if column == "price.currency" {
+					column = "currency"
+				}
+				resp.Errors = append(resp.Errors, &ImportRowError{Row: rows[i], Column: column, Error: "validation failed", Details: f.Message})
+			}
+			continue
+		}
+		rowErr := &ImportRowError{Row: rows[i], Error: "invalid row", Details: err.Error()}
+		if errors.Is(err, services.ErrForbidden) {
+			rowErr.Column, rowErr.Error = "user_id", "forbidden"
+		}
+		resp.Errors = append(resp.Errors, rowErr)
+	}
+	sort.SliceStable(resp.Errors, func(i, j int) bool {
+		return resp.Errors[i].Row < resp.Errors[j].Row
+	})
+
+	failedRows := make(map[int]bool)
+	for _, rowErr := range resp.Errors {
+		failedRows[rowErr.Row] = true
+	}
+	resp.Valid = resp.Rows - len(failedRows)
+
+	switch {
+	case !commit:
+		c.JSON(http.StatusOK, resp)
+	case len(resp.Errors) > 0:
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"path":   c.Request.URL.Path,
+			"errors": len(resp.Errors),
+		}).Warn("Import has invalid rows")
+	default:
+		resp.Services = srvs
+		c.JSON(http.StatusCreated, resp)
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+)
+
+// serviceDocument is the service as the CreateRequest that would create it;
+// merge patches apply to this document. The dates keep their days, which
+// MM-YYYY would lose.
+func serviceDocument(srv *services.Service) *CreateRequest {
+	doc := &CreateRequest{
+		ServiceName:     srv.ServiceName,
+		Price:           srv.Price,
+		UserID:          srv.UserID,
+		StartDate:       srv.StartDate.Format(time.DateOnly),
+		BillingPeriod:   (*string)(&srv.BillingPeriod),
+		BillingInterval: &srv.BillingInterval,
+	}
+	if srv.EndDate != nil {
+		endDate := srv.EndDate.Format(time.DateOnly)
+		doc.EndDate = &endDate
+	}
+	return doc
+}
+
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// checkMergePatch rejects the patches that cannot apply to any service.
+func checkMergePatch(patch []byte) error {
+	value, err := decodeJSON(patch)
+	if err != nil {
+		return err
+	}
+	object, ok := value.(map[string]any)
+	if !ok {
+		return errors.New("a merge patch of a service must be a JSON object")
+	}
+	if price, ok := object["price"]; ok && price == nil {
+		return errors.New("price cannot be removed")
+	}
+
+	var req UpdateRequest
+	return json.Unmarshal(patch, &req)
+}
+
+// mergePatch applies the merge patch to target as RFC 7396 describes.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = make(map[string]any)
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+	return targetObject
+}
+
+// applyMergePatch patches srv and validates the result like a create request.
+// The errors of the patch are *requestError.
+func applyMergePatch(srv *services.Service, patch []byte) error {
+	doc, err := json.Marshal(serviceDocument(srv))
+	if err != nil {
+		return err
+	}
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return err
+	}
+	patchValue, err := decodeJSON(patch)
+	if err != nil {
+		return &requestError{"invalid request body", err}
+	}
+	// A bare amount changes the amount only; on its own it would mean the
+	// default currency.
+	if patchObject, ok := patchValue.(map[string]any); ok {
+		if amount, ok := patchObject["price"].(string); ok {
+			patchObject["price"] = map[string]any{"amount": amount}
+		}
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patchValue))
+	if err != nil {
+		return &requestError{"invalid request body", err}
+	}
+	var req CreateRequest
+	if err := json.Unmarshal(merged, &req); err != nil {
+		return &requestError{"invalid request body", err}
+	}
+
+	patched, reqErr := req.service()
+	if reqErr != nil {
+		return reqErr
+	}
+	patched.ID, patched.Version = srv.ID, srv.Version
+	*srv = *patched
+
+	return nil
+}
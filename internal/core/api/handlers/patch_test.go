@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/google/uuid"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	endDate := time.Date(2025, time.March, 20, 0, 0, 0, 0, time.UTC)
+	base := services.Service{
+		ID:              uuid.New(),
+		ServiceName:     "Yandex Plus",
+		Price:           services.Money{Amount: 999, Currency: "USD"},
+		UserID:          uuid.New(),
+		StartDate:       time.Date(2024, time.January, 15, 0, 0, 0, 0, time.UTC),
+		EndDate:         &endDate,
+		BillingPeriod:   services.BillingPeriodWeek,
+		BillingInterval: 1,
+		Version:         3,
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		check func(t *testing.T, srv *services.Service)
+	}{
+		{
+			name:  "rename keeps the days of the dates",
+			patch: `{"service_name": "Kinopoisk"}`,
+			check: func(t *testing.T, srv *services.Service) {
+				if srv.ServiceName != "Kinopoisk" {
+					t.Errorf("service name: got %q, want Kinopoisk", srv.ServiceName)
+				}
+				if !srv.StartDate.Equal(base.StartDate) || srv.EndDate == nil || !srv.EndDate.Equal(endDate) {
+					t.Errorf("dates: got %s - %v, want %s - %s", srv.StartDate, srv.EndDate, base.StartDate, endDate)
+				}
+			},
+		},
+		{
+			name:  "bare amount keeps the currency",
+			patch: `{"price": "12.50"}`,
+			check: func(t *testing.T, srv *services.Service) {
+				if want := (services.Money{Amount: 1250, Currency: "USD"}); srv.Price != want {
+					t.Errorf("price: got %s %s, want %s %s", srv.Price, srv.Price.Currency, want, want.Currency)
+				}
+			},
+		},
+		{
+			name:  "amount object keeps the currency",
+			patch: `{"price": {"amount": "7"}}`,
+			check: func(t *testing.T, srv *services.Service) {
+				if want := (services.Money{Amount: 700, Currency: "USD"}); srv.Price != want {
+					t.Errorf("price: got %s %s, want %s %s", srv.Price, srv.Price.Currency, want, want.Currency)
+				}
+			},
+		},
+		{
+			name:  "null end date reopens the service",
+			patch: `{"end_date": null}`,
+			check: func(t *testing.T, srv *services.Service) {
+				if srv.EndDate != nil {
+					t.Errorf("end date: got %s, want none", srv.EndDate)
+				}
+				if !srv.StartDate.Equal(base.StartDate) {
+					t.Errorf("start date: got %s, want %s", srv.StartDate, base.StartDate)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkMergePatch([]byte(tt.patch)); err != nil {
+				t.Fatalf("checkMergePatch rejected the patch: %v", err)
+			}
+			srv := base
+			if err := applyMergePatch(&srv, []byte(tt.patch)); err != nil {
+				t.Fatalf("applyMergePatch failed: %v", err)
+			}
+			if srv.ID != base.ID || srv.Version != base.Version {
+				t.Errorf("identity: got %s v%d, want %s v%d", srv.ID, srv.Version, base.ID, base.Version)
+			}
+			tt.check(t, &srv)
+		})
+	}
+}
+
+func TestCheckMergePatchRejects(t *testing.T) {
+	for _, patch := range []string{`[]`, `{"price": null}`, `{"billing_interval": "two"}`} {
+		if err := checkMergePatch([]byte(patch)); err == nil {
+			t.Errorf("%s: accepted", patch)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ExchangeRateService interface {
+	CreateExchangeRate(ctx context.Context, rate *services.ExchangeRate) error
+	GetExchangeRate(ctx context.Context, ID uuid.UUID) (*services.ExchangeRate, error)
+	GetExchangeRates(ctx context.Context, fromCurrency, toCurrency string) ([]*services.ExchangeRate, error)
+	UpdateExchangeRate(ctx context.Context, rate *services.ExchangeRate) error
+	DeleteExchangeRate(ctx context.Context, ID uuid.UUID) error
+}
+
+type ExchangeRateHandler struct {
+	exchangeRateService ExchangeRateService
+}
+
+func NewExchangeRateHandler(ers ExchangeRateService) *ExchangeRateHandler {
+	return &ExchangeRateHandler{
+		exchangeRateService: ers,
+	}
+}
+
+type ExchangeRateRequest struct {
+	FromCurrency string `json:"from_currency" example:"USD"`
+	ToCurrency   string `json:"to_currency" example:"RUB"`
+	Month        string `json:"month" example:"01-2024"`
+	Rate         string `json:"rate" example:"89.6883"`
+}
+
+func bindExchangeRate(c *gin.Context) (*services.ExchangeRate, bool) {
+	var req ExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		problem.Write(c, &requestError{"invalid request body", err})
+		return nil, false
+	}
+
+	month, err := time.Parse(dateLayout, req.Month)
+	if err != nil {
+		problem.Write(c, &requestError{"invalid month", err})
+		return nil, false
+	}
+
+	return &services.ExchangeRate{
+		FromCurrency: req.FromCurrency,
+		ToCurrency:   req.ToCurrency,
+		Month:        month,
+		Rate:         req.Rate,
+	}, true
+}
+
+// CreateExchangeRate godoc
+// @Summary      Create an exchange rate
+// @Description  Sets the rate converting one currency to another from the given month until the next rate of the same pair. The opposite direction is derived when it has no rate of its own.
+// @Tags         rates
+// @Accept       json
+// @Produce      json
+// @Param        request  body  ExchangeRateRequest  true  "Currency pair, month and rate"
+// @Success      201  {object}  services.ExchangeRate
+// @Failure      400  {object}  problem.Problem  "Invalid request body, currency, rate or date format"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can change exchange rates"
+// @Failure      409  {object}  problem.Problem  "Rate for the pair and month already exists"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /rates [post]
+func (h *ExchangeRateHandler) CreateExchangeRate(c *gin.Context) {
+	rate, ok := bindExchangeRate(c)
+	if !ok {
+		return
+	}
+
+	if err := h.exchangeRateService.CreateExchangeRate(c, rate); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rate)
+}
+
+// GetExchangeRate godoc
+// @Summary      Get an exchange rate
+// @Tags         rates
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the exchange rate" example:"123e4567-e89b-12d3-a456-426614174010"
+// @Success      200  {object}  services.ExchangeRate
+// @Failure      400  {object}  problem.Problem  "Invalid UUID"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem  "API key without the scope of the route"
+// @Failure      404  {object}  problem.Problem  "Exchange rate not found"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /rates/{id} [get]
+func (h *ExchangeRateHandler) GetExchangeRate(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	rate, err := h.exchangeRateService.GetExchangeRate(c, ID)
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+// GetExchangeRates godoc
+// @Summary      List exchange rates
+// @Description  Lists exchange rates ordered by currency pair and month, optionally only for the given currencies.
+// @Tags         rates
+// @Accept       json
+// @Produce      json
+// @Param        from  query  string  false  "Source currency"  example:"USD"
+// @Param        to    query  string  false  "Target currency"  example:"RUB"
+// @Success      200  {array}   services.ExchangeRate
+// @Failure      400  {object}  problem.Problem  "Invalid currency"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token or API key"
+// @Failure      403  {object}  problem.Problem  "API key without the scope of the route"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Security     ApiKeyAuth
+// @Router       /rates [get]
+func (h *ExchangeRateHandler) GetExchangeRates(c *gin.Context) {
+	var currencies [2]string
+	for i, param := range []string{"from", "to"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+
+		currency, err := services.ParseCurrency(value)
+		if err != nil {
+			problem.Write(c, &requestError{"invalid currency", err})
+			return
+		}
+		currencies[i] = currency
+	}
+
+	rates, err := h.exchangeRateService.GetExchangeRates(c, currencies[0], currencies[1])
+	if err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// UpdateExchangeRate godoc
+// @Summary      Replace an exchange rate
+// @Tags         rates
+// @Accept       json
+// @Produce      json
+// @Param        ID       path  string               true  "UUID of the exchange rate" example:"123e4567-e89b-12d3-a456-426614174010"
+// @Param        request  body  ExchangeRateRequest  true  "Currency pair, month and rate"
+// @Success      200  {object}  services.ExchangeRate
+// @Failure      400  {object}  problem.Problem  "Invalid UUID, request body, currency, rate or date format"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can change exchange rates"
+// @Failure      404  {object}  problem.Problem  "Exchange rate not found"
+// @Failure      409  {object}  problem.Problem  "Rate for the pair and month already exists"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /rates/{id} [put]
+func (h *ExchangeRateHandler) UpdateExchangeRate(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	rate, ok := bindExchangeRate(c)
+	if !ok {
+		return
+	}
+	rate.ID = ID
+
+	if err := h.exchangeRateService.UpdateExchangeRate(c, rate); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rate)
+}
+
+// DeleteExchangeRate godoc
+// @Summary      Delete an exchange rate
+// @Tags         rates
+// @Accept       json
+// @Produce      json
+// @Param        ID  path  string  true  "UUID of the exchange rate" example:"123e4567-e89b-12d3-a456-426614174010"
+// @Success      204  "Successfully deleted the exchange rate"
+// @Failure      400  {object}  problem.Problem  "Invalid UUID"
+// @Failure      401  {object}  problem.Problem  "Missing or invalid bearer token"
+// @Failure      403  {object}  problem.Problem  "Only admins can change exchange rates"
+// @Failure      404  {object}  problem.Problem  "Exchange rate not found"
+// @Failure      500  {object}  problem.Problem  "Internal server error"
+// @Security     BearerAuth
+// @Router       /rates/{id} [delete]
+func (h *ExchangeRateHandler) DeleteExchangeRate(c *gin.Context) {
+	ID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		problem.Write(c, &requestError{"invalid UUID", err})
+		return
+	}
+
+	if err := h.exchangeRateService.DeleteExchangeRate(c, ID); err != nil {
+		problem.Write(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
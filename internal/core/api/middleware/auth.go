@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// JWTConfig holds the keys bearer tokens are verified with. HS256 tokens are
+// accepted when Secret is set, RS256 tokens when PublicKey is set.
+type JWTConfig struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+}
+
+type tokenClaims struct {
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func (cfg *JWTConfig) validMethods() []string {
+	var methods []string
+	if len(cfg.Secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.PublicKey != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	return methods
+}
+
+func (cfg *JWTConfig) key(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		return cfg.Secret, nil
+	case *jwt.SigningMethodRSA:
+		return cfg.PublicKey, nil
+	default:
+		return nil, errors.New("unexpected signing method")
+	}
+}
+
+func (cfg *JWTConfig) principal(tokenString string) (*services.Principal, error) {
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, cfg.key,
+		jwt.WithValidMethods(cfg.validMethods()),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, errors.New("subject is not a user UUID")
+	}
+	role, err := services.ParseRole(claims.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services.Principal{Subject: subject, Role: role}, nil
+}
+
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*services.Principal, error)
+}
+
+// Authenticate verifies the X-API-Key header of service clients or else the
+// bearer token of users and puts the caller into the request context; the
+// engine needs ContextWithFallback so that handlers passing the gin context on
+// see it.
+func Authenticate(cfg *JWTConfig, apiKeys APIKeyAuthenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader("X-API-Key"); key != "" {
+			principal, err := apiKeys.AuthenticateAPIKey(c.Request.Context(), key)
+			if err != nil {
+				problem.Write(c, err)
+				return
+			}
+
+			c.Request = c.Request.WithContext(withPrincipal(c.Request.Context(), principal))
+			c.Next()
+			return
+		}
+
+		tokenString, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || tokenString == "" {
+			c.Header("WWW-Authenticate", "Bearer")
+			problem.Write(c, fmt.Errorf("%w: missing bearer token", services.ErrUnauthenticated))
+			return
+		}
+
+		principal, err := cfg.principal(tokenString)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			problem.Write(c, fmt.Errorf("%w: invalid token: %v", services.ErrUnauthenticated, err))
+			return
+		}
+
+		c.Request = c.Request.WithContext(withPrincipal(c.Request.Context(), principal))
+		c.Next()
+	}
+}
+
+// withPrincipal also names the caller in the log entries about the request.
+func withPrincipal(ctx context.Context, principal *services.Principal) context.Context {
+	ctx = logging.WithFields(ctx, logrus.Fields{"user": principal.Subject.String()})
+	return services.WithPrincipal(ctx, principal)
+}
+
+// RequireScope rejects API key clients whose key lacks the scope of the route.
+func RequireScope(scope services.Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := services.PrincipalFromContext(c.Request.Context())
+		if !ok || !principal.HasScope(scope) {
+			problem.Write(c, fmt.Errorf("%w: route requires scope %s", services.ErrForbidden, scope))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func RequireRole(role services.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := services.PrincipalFromContext(c.Request.Context())
+		if !ok || principal.Role != role {
+			problem.Write(c, fmt.Errorf("%w: route requires role %s", services.ErrForbidden, role))
+			return
+		}
+
+		c.Next()
+	}
+}
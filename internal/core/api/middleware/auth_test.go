@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestAuthenticateBearerTokens(t *testing.T) {
+	secret := []byte("test-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	cfg := &JWTConfig{Secret: secret, PublicKey: &rsaKey.PublicKey}
+
+	userID := uuid.New()
+	valid := jwt.MapClaims{"sub": userID.String(), "exp": time.Now().Add(time.Hour).Unix()}
+	with := func(key string, value any) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+		return claims
+	}
+	sign := func(method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		admin    bool
+		wantCode int
+		wantRole services.Role
+	}{
+		{"HS256", "Bearer " + sign(jwt.SigningMethodHS256, secret, valid), false, http.StatusOK, services.RoleUser},
+		{"RS256", "Bearer " + sign(jwt.SigningMethodRS256, rsaKey, valid), false, http.StatusOK, services.RoleUser},
+		{"admin", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("role", "admin")), false, http.StatusOK, services.RoleAdmin},
+		{"admin route as admin", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("role", "admin")), true, http.StatusOK, services.RoleAdmin},
+		{"admin route as user", "Bearer " + sign(jwt.SigningMethodHS256, secret, valid), true, http.StatusForbidden, ""},
+		{"no header", "", false, http.StatusUnauthorized, ""},
+		{"not a bearer token", "Basic dXNlcjpwYXNz", false, http.StatusUnauthorized, ""},
+		{"expired", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("exp", time.Now().Add(-time.Minute).Unix())), false, http.StatusUnauthorized, ""},
+		{"no expiry", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("exp", nil)), false, http.StatusUnauthorized, ""},
+		{"wrong secret", "Bearer " + sign(jwt.SigningMethodHS256, []byte("other-secret"), valid), false, http.StatusUnauthorized, ""},
+		{"wrong RSA key", "Bearer " + sign(jwt.SigningMethodRS256, otherKey, valid), false, http.StatusUnauthorized, ""},
+		{"HS512", "Bearer " + sign(jwt.SigningMethodHS512, secret, valid), false, http.StatusUnauthorized, ""},
+		{"alg none", "Bearer " + sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), false, http.StatusUnauthorized, ""},
+		{"missing sub", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("sub", nil)), false, http.StatusUnauthorized, ""},
+		{"sub is not a UUID", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("sub", "alice")), false, http.StatusUnauthorized, ""},
+		{"unknown role", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("role", "root")), false, http.StatusUnauthorized, ""},
+		{"service role is not for tokens", "Bearer " + sign(jwt.SigningMethodHS256, secret, with("role", "service")), false, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal *services.Principal
+			handlers := []gin.HandlerFunc{Authenticate(cfg, nil)}
+			if tt.admin {
+				handlers = append(handlers, RequireRole(services.RoleAdmin))
+			}
+			handlers = append(handlers, func(c *gin.Context) {
+				principal, _ = services.PrincipalFromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			router := gin.New()
+			router.GET("/service", handlers...)
+
+			req := httptest.NewRequest(http.MethodGet, "/service", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+			if tt.wantCode == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if principal == nil {
+				t.Fatal("no principal in the request context")
+			}
+			if principal.Subject != userID || principal.Role != tt.wantRole {
+				t.Errorf("got principal %s %s, want %s %s", principal.Subject, principal.Role, userID, tt.wantRole)
+			}
+		})
+	}
+}
+
+// apiKeysFunc adapts a function to APIKeyAuthenticator.
+type apiKeysFunc func(key string) (*services.Principal, error)
+
+func (f apiKeysFunc) AuthenticateAPIKey(_ context.Context, key string) (*services.Principal, error) {
+	return f(key)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	keys := map[string][]services.Scope{
+		"emk_reader":   {services.ScopeRead},
+		"emk_writer":   {services.ScopeRead, services.ScopeWrite},
+		"emk_cumulate": {services.ScopeCumulate},
+	}
+	apiKeys := apiKeysFunc(func(key string) (*services.Principal, error) {
+		scopes, ok := keys[key]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown API key", services.ErrUnauthenticated)
+		}
+		return &services.Principal{Subject: uuid.New(), Role: services.RoleService, Scopes: scopes}, nil
+	})
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	group := router.Group("/service", Authenticate(&JWTConfig{Secret: []byte("test-secret")}, apiKeys))
+	group.GET("", RequireScope(services.ScopeRead), ok)
+	group.POST("", RequireScope(services.ScopeWrite), ok)
+	group.GET("/cumulate", RequireScope(services.ScopeCumulate), ok)
+	group.POST("/purge", RequireRole(services.RoleAdmin), ok)
+
+	tests := []struct {
+		key      string
+		method   string
+		path     string
+		wantCode int
+	}{
+		{"emk_reader", http.MethodGet, "/service", http.StatusOK},
+		{"emk_reader", http.MethodPost, "/service", http.StatusForbidden},
+		{"emk_reader", http.MethodGet, "/service/cumulate", http.StatusForbidden},
+		{"emk_writer", http.MethodPost, "/service", http.StatusOK},
+		{"emk_writer", http.MethodGet, "/service/cumulate", http.StatusForbidden},
+		{"emk_cumulate", http.MethodGet, "/service/cumulate", http.StatusOK},
+		{"emk_cumulate", http.MethodGet, "/service", http.StatusForbidden},
+		{"emk_writer", http.MethodPost, "/service/purge", http.StatusForbidden},
+		{"emk_unknown", http.MethodGet, "/service", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key+" "+tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-API-Key", tt.key)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.wantCode, rec.Body)
+			}
+		})
+	}
+}
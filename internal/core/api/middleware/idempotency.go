@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const maxIdempotentBody = 1 << 20 // 1 MB
+
+type IdempotencyStore interface {
+	BeginRequest(ctx context.Context, key, method, path string, body []byte) (*services.IdempotencyRecord, error)
+	CompleteRequest(ctx context.Context, key string, status int, response []byte) error
+	ReleaseRequest(ctx context.Context, key string) error
+}
+
+// responseRecorder keeps a copy of the response body on its way out.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes requests carrying an Idempotency-Key header safe to retry:
+// a repeated request gets the response of the first one instead of running
+// again. Server errors are not remembered, so the request can be retried with
+// the same key. It needs Authenticate in front, keys are scoped to the caller.
+func Idempotency(store IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBody))
+		if err != nil {
+			problem.Write(c, fmt.Errorf("%w: invalid request body: %v", services.ErrInvalidRequest, err))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		replay, err := store.BeginRequest(c.Request.Context(), key, c.Request.Method, c.Request.URL.Path, body)
+		if err != nil {
+			problem.Write(c, err)
+			return
+		}
+
+		if replay != nil {
+			contentType := gin.MIMEJSON + "; charset=utf-8"
+			if replay.Status >= http.StatusBadRequest {
+				contentType = problem.ContentType
+			}
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(replay.Status, contentType, replay.Response)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			// Recovery answers a panic with a server error, so the key is
+			// released for the retry like after any other server error.
+			if p := recover(); p != nil {
+				logStoreError(c, store.ReleaseRequest(c.Request.Context(), key))
+				panic(p)
+			}
+		}()
+		c.Next()
+
+		// The response is out already; failing to remember it only costs the
+		// client a rejected retry.
+		if status := recorder.Status(); status >= http.StatusInternalServerError {
+			err = store.ReleaseRequest(c.Request.Context(), key)
+		} else {
+			err = store.CompleteRequest(c.Request.Context(), key, status, recorder.body.Bytes())
+		}
+		logStoreError(c, err)
+	}
+}
+
+func logStoreError(c *gin.Context, err error) {
+	if err == nil {
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+		"path":    c.Request.URL.Path,
+		"details": err.Error(),
+	}).Error("Failed to store idempotency key")
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// idempotencyKeys records how the requests it began ended.
+type idempotencyKeys struct {
+	completed map[string]int
+	released  []string
+}
+
+func (k *idempotencyKeys) BeginRequest(context.Context, string, string, string, []byte) (*services.IdempotencyRecord, error) {
+	return nil, nil
+}
+
+func (k *idempotencyKeys) CompleteRequest(_ context.Context, key string, status int, _ []byte) error {
+	k.completed[key] = status
+	return nil
+}
+
+func (k *idempotencyKeys) ReleaseRequest(_ context.Context, key string) error {
+	k.released = append(k.released, key)
+	return nil
+}
+
+func TestIdempotencyReleasesFailedRequests(t *testing.T) {
+	std := logrus.StandardLogger()
+	output := std.Out
+	std.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		std.SetOutput(output)
+	})
+
+	tests := []struct {
+		name         string
+		handler      gin.HandlerFunc
+		wantCode     int
+		wantReleased bool
+	}{
+		{"created", func(c *gin.Context) { c.JSON(http.StatusCreated, gin.H{"id": 1}) }, http.StatusCreated, false},
+		{"rejected", func(c *gin.Context) { c.Status(http.StatusUnprocessableEntity) }, http.StatusUnprocessableEntity, false},
+		{"server error", func(c *gin.Context) { c.Status(http.StatusServiceUnavailable) }, http.StatusServiceUnavailable, true},
+		{"panic", func(c *gin.Context) { panic("boom") }, http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := &idempotencyKeys{completed: make(map[string]int)}
+			router := gin.New()
+			router.Use(Recovery(), Idempotency(keys))
+			router.POST("/service", tt.handler)
+
+			req := httptest.NewRequest(http.MethodPost, "/service", nil)
+			req.Header.Set("Idempotency-Key", "key-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantReleased {
+				if len(keys.released) != 1 || len(keys.completed) != 0 {
+					t.Errorf("released %v and completed %v, want the key released once", keys.released, keys.completed)
+				}
+				return
+			}
+			if len(keys.released) != 0 || keys.completed["key-1"] != tt.wantCode {
+				t.Errorf("released %v and completed %v, want the key completed with %d", keys.released, keys.completed, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"slices"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/problem"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AccessLog logs every request once it is served, through the logger
+// RequestID put into the context. The requests to the quiet routes, such as
+// probes and scrapes, are logged at the debug level only.
+func AccessLog(quiet ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		entry := logging.FromContext(c.Request.Context()).WithFields(logrus.Fields{
+			"path":      c.Request.URL.Path,
+			"status":    c.Writer.Status(),
+			"size":      c.Writer.Size(),
+			"client_ip": c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("details", c.Errors.String())
+		}
+		level := logrus.InfoLevel
+		if slices.Contains(quiet, c.FullPath()) {
+			level = logrus.DebugLevel
+		}
+		entry.Log(level, "Request served")
+	}
+}
+
+// Recovery turns a panic into an internal error problem and logs the stack
+// with the request ID; the clients that hung up get no response.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		err := fmt.Errorf("panic: %v\n%s", recovered, debug.Stack())
+		problem.WriteProblem(c, problem.New(http.StatusInternalServerError, "internal", "Internal server error", ""), err)
+	})
+}
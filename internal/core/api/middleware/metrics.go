@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics counts and times the requests per route template rather than path,
+// so that IDs do not multiply the series; requests matching no route share
+// the "unmatched" one.
+func Metrics(reg prometheus.Registerer) gin.HandlerFunc {
+	labels := []string{"method", "route", "status"}
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Requests by method, route and status.",
+	}, labels)
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of the requests by method, route and status.",
+		Buckets: prometheus.DefBuckets,
+	}, labels)
+	reg.MustRegister(requests, duration)
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		values := []string{c.Request.Method, route, strconv.Itoa(c.Writer.Status())}
+		requests.WithLabelValues(values...).Inc()
+		duration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+	}
+}
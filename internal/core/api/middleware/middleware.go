@@ -17,7 +17,7 @@ const maxLogBody = 1 << 20 // 1 MB
 func DebugRequestLogger() gin.HandlerFunc {
 	isSensitive := func(k string) bool {
 		k = strings.ToLower(k)
-		return k == "authorization" || k == "cookie" || k == "set-cookie" || k == "proxy-authorization"
+		return k == "authorization" || k == "cookie" || k == "set-cookie" || k == "proxy-authorization" || k == "x-api-key"
 	}
 
 	return func(c *gin.Context) {
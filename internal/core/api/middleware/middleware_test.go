@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDebugRequestLoggerRedactsCredentials(t *testing.T) {
+	var out bytes.Buffer
+	std := logrus.StandardLogger()
+	output, level := std.Out, std.GetLevel()
+	std.SetOutput(&out)
+	std.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		std.SetOutput(output)
+		std.SetLevel(level)
+	})
+
+	router := gin.New()
+	router.Use(DebugRequestLogger())
+	router.GET("/service", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/service", nil)
+	req.Header.Set("X-API-Key", "emk_secret-key")
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cookie", "session=secret-cookie")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	logged := out.String()
+	if !strings.Contains(logged, "[REDACTED]") {
+		t.Fatalf("request was not logged: %s", logged)
+	}
+	for _, secret := range []string{"emk_secret-key", "secret-token", "secret-cookie"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("%s leaks into the log: %s", secret, logged)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/requestid"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxRequestIDLength bounds the request IDs taken over from clients.
+const maxRequestIDLength = 128
+
+// RequestID takes the request ID over from the X-Request-ID header or makes
+// one up, puts it into the request context along with the logger of the
+// request and echoes it in the response.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(requestid.Header)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.NewString()
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		logger := logrus.WithFields(logrus.Fields{
+			"request_id": id,
+			"method":     c.Request.Method,
+			"route":      route,
+		})
+
+		ctx := requestid.NewContext(c.Request.Context(), id)
+		c.Request = c.Request.WithContext(logging.NewContext(ctx, logger, start))
+		c.Header(requestid.Header, id)
+		c.Next()
+	}
+}
@@ -0,0 +1,107 @@
+// Package problem renders errors as RFC 7807 problem details. Only the
+// messages of domain errors reach the clients; the rest is logged along with
+// the request ID the response carries.
+package problem
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/requestid"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/sirupsen/logrus"
+)
+
+const ContentType = "application/problem+json"
+
+// unauthenticatedDetail replaces the reasons credentials are refused for;
+// they come from the JWT library and would only help forging a token, so
+// they are logged instead.
+const unauthenticatedDetail = "missing or invalid credentials"
+
+// typeBase prefixes the codes of the problem types; the type URIs are
+// relative to the API and never change for a code.
+const typeBase = "/problems/"
+
+type Problem struct {
+	Type      string                 `json:"type" example:"/problems/not-found"`
+	Title     string                 `json:"title" example:"Not found"`
+	Status    int                    `json:"status" example:"404"`
+	Detail    string                 `json:"detail,omitempty" example:"not found"`
+	Instance  string                 `json:"instance,omitempty" example:"/service/3fa85f64-5717-4562-b3fc-2c963f66afa6"`
+	RequestID string                 `json:"request_id,omitempty" example:"5d1f0c8e-7a51-4b7e-9b8e-3f1b2c4d5e6f"`
+	Fields    []*services.FieldError `json:"fields,omitempty"`
+}
+
+var kindStatus = map[services.ErrorKind]int{
+	services.KindInvalid:         http.StatusBadRequest,
+	services.KindUnauthenticated: http.StatusUnauthorized,
+	services.KindForbidden:       http.StatusForbidden,
+	services.KindNotFound:        http.StatusNotFound,
+	services.KindConflict:        http.StatusConflict,
+	services.KindPrecondition:    http.StatusPreconditionFailed,
+	services.KindValidation:      http.StatusUnprocessableEntity,
+	services.KindAborted:         http.StatusFailedDependency,
+}
+
+func New(status int, code, title, detail string) *Problem {
+	return &Problem{
+		Type:   typeBase + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// FromError describes err; errors that are not domain errors become an
+// internal error without details.
+func FromError(err error) *Problem {
+	domainErr, ok := services.AsError(err)
+	status, known := kindStatus[services.KindOf(err)]
+	if !ok || !known {
+		return New(http.StatusInternalServerError, "internal", "Internal server error", "")
+	}
+
+	detail := err.Error()
+	if domainErr.Kind == services.KindUnauthenticated {
+		detail = unauthenticatedDetail
+	}
+
+	p := New(status, domainErr.Code, domainErr.Title(), detail)
+	var validationErr *services.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Fields = validationErr.Fields
+	}
+	return p
+}
+
+// Write responds with the problem err is and logs err in full.
+func Write(c *gin.Context, err error) {
+	WriteProblem(c, FromError(err), err)
+}
+
+// WriteProblem responds with p and aborts the request.
+func WriteProblem(c *gin.Context, p *Problem, err error) {
+	p.Instance = c.Request.URL.Path
+	p.RequestID = requestid.FromContext(c.Request.Context())
+
+	fields := logrus.Fields{
+		"path":   c.Request.URL.Path,
+		"status": p.Status,
+	}
+	if err != nil {
+		fields["details"] = err.Error()
+	}
+	if entry := logging.FromContext(c.Request.Context()).WithFields(fields); p.Status >= http.StatusInternalServerError {
+		entry.Error(p.Title)
+	} else {
+		entry.Warn(p.Title)
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.Render(p.Status, render.JSON{Data: p})
+	c.Abort()
+}
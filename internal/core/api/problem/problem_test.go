@@ -0,0 +1,116 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/requestid"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestErrorsRenderAsProblems(t *testing.T) {
+	invalid := (&services.Service{
+		Price:           services.Money{Amount: 100, Currency: "RUB"},
+		UserID:          uuid.New(),
+		StartDate:       time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		BillingPeriod:   services.BillingPeriodMonth,
+		BillingInterval: 1,
+	}).Validate()
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantType   string
+		wantDetail string
+		wantFields []string
+	}{
+		{
+			name:       "not found",
+			err:        services.ErrNotFound,
+			wantStatus: http.StatusNotFound,
+			wantType:   "/problems/not-found",
+			wantDetail: "not found",
+		},
+		{
+			name:       "wrapped domain error",
+			err:        fmt.Errorf("%w: route requires scope write", services.ErrForbidden),
+			wantStatus: http.StatusForbidden,
+			wantType:   "/problems/forbidden",
+			wantDetail: "forbidden: route requires scope write",
+		},
+		{
+			name:       "validation",
+			err:        invalid,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantType:   "/problems/validation",
+			wantDetail: invalid.Error(),
+			wantFields: []string{"service_name"},
+		},
+		{
+			name:       "invalid token",
+			err:        fmt.Errorf("%w: invalid token: %w", services.ErrUnauthenticated, jwt.ErrTokenExpired),
+			wantStatus: http.StatusUnauthorized,
+			wantType:   "/problems/unauthenticated",
+			wantDetail: unauthenticatedDetail,
+		},
+		{
+			name:       "storage failure",
+			err:        fmt.Errorf("failed to query services: %w", errors.New("connection refused")),
+			wantStatus: http.StatusInternalServerError,
+			wantType:   "/problems/internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := FromError(tt.err)
+			if p.Status != tt.wantStatus || p.Type != tt.wantType || p.Detail != tt.wantDetail {
+				t.Errorf("got %d %s %q, want %d %s %q", p.Status, p.Type, p.Detail, tt.wantStatus, tt.wantType, tt.wantDetail)
+			}
+
+			var fields []string
+			for _, field := range p.Fields {
+				fields = append(fields, field.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("got fields %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestWriteRespondsWithProblem(t *testing.T) {
+	router := gin.New()
+	router.GET("/service/:id", func(c *gin.Context) {
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), "req-1"))
+		Write(c, fmt.Errorf("%w: invalid token: %w", services.ErrUnauthenticated, jwt.ErrTokenSignatureInvalid))
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/service/42", nil))
+
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("got %d %s, want 401 %s", rec.Code, rec.Header().Get("Content-Type"), ContentType)
+	}
+	if strings.Contains(rec.Body.String(), "signature") {
+		t.Errorf("the JWT error leaks into the response: %s", rec.Body)
+	}
+
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("response is not a problem: %v", err)
+	}
+	if p.Instance != "/service/42" || p.RequestID != "req-1" {
+		t.Errorf("got instance %q and request ID %q, want /service/42 and req-1", p.Instance, p.RequestID)
+	}
+}
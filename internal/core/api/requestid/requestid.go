@@ -0,0 +1,19 @@
+// Package requestid carries the ID of the request being served, which ties
+// the response to the log entries about it.
+package requestid
+
+import "context"
+
+// Header is the request and response header of the ID.
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
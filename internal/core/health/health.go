@@ -0,0 +1,76 @@
+// Package health tells the orchestrator whether the service should get
+// traffic.
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// pingTimeout bounds every query a readiness check makes.
+const pingTimeout = 2 * time.Second
+
+var (
+	ErrShuttingDown        = errors.New("shutting down")
+	ErrDatabaseUnreachable = errors.New("database is unreachable")
+	ErrMigrationsBehind    = errors.New("migrations are not applied")
+)
+
+// Readiness is ready while the database answers, its schema is at least at
+// the version the service was built with and the shutdown hasn't begun.
+type Readiness struct {
+	db               *sql.DB
+	migrationsTable  string
+	migrationVersion uint
+
+	shuttingDown atomic.Bool
+}
+
+func NewReadiness(db *sql.DB, migrationsTable string, migrationVersion uint) *Readiness {
+	return &Readiness{
+		db:               db,
+		migrationsTable:  migrationsTable,
+		migrationVersion: migrationVersion,
+	}
+}
+
+// ShutDown fails every check from now on.
+func (r *Readiness) ShutDown() {
+	r.shuttingDown.Store(true)
+}
+
+// Ready reports the first reason not to send traffic, nil if there is none.
+func (r *Readiness) Ready(ctx context.Context) error {
+	if r.shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	if err := r.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("%w: %v", ErrDatabaseUnreachable, err)
+	}
+
+	var version uint
+	var dirty bool
+	query := fmt.Sprintf("SELECT version, dirty FROM %s LIMIT 1", r.migrationsTable)
+	if err := r.db.QueryRowContext(ctx, query).Scan(&version, &dirty); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: none applied, want version %d", ErrMigrationsBehind, r.migrationVersion)
+		}
+		return fmt.Errorf("%w: %v", ErrDatabaseUnreachable, err)
+	}
+	if dirty {
+		return fmt.Errorf("%w: version %d is dirty", ErrMigrationsBehind, version)
+	}
+	if version < r.migrationVersion {
+		return fmt.Errorf("%w: at version %d, want %d", ErrMigrationsBehind, version, r.migrationVersion)
+	}
+
+	return nil
+}
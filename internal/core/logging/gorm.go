@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm/logger"
+)
+
+// gormLogger writes the queries GORM makes to the logger of the request that
+// made them: every query at the debug level and the slow ones as warnings.
+// The failures are left to the callers, which see the errors anyway.
+type gormLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+func NewGormLogger(slowThreshold time.Duration) logger.Interface {
+	return &gormLogger{level: logger.Warn, slowThreshold: slowThreshold}
+}
+
+func (gl *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &gormLogger{level: level, slowThreshold: gl.slowThreshold}
+}
+
+func (gl *gormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if gl.level >= logger.Info {
+		FromContext(ctx).Infof(msg, args...)
+	}
+}
+
+func (gl *gormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if gl.level >= logger.Warn {
+		FromContext(ctx).Warnf(msg, args...)
+	}
+}
+
+func (gl *gormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if gl.level >= logger.Error {
+		FromContext(ctx).Errorf(msg, args...)
+	}
+}
+
+func (gl *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if gl.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	slow := gl.slowThreshold > 0 && elapsed > gl.slowThreshold && gl.level >= logger.Warn
+	level := logrus.DebugLevel
+	switch {
+	case slow:
+		level = logrus.WarnLevel
+	case gl.level >= logger.Info:
+		level = logrus.InfoLevel
+	}
+
+	entry := FromContext(ctx)
+	if !entry.Logger.IsLevelEnabled(level) {
+		return
+	}
+
+	sql, rows := fc()
+	fields := logrus.Fields{
+		"sql":        sql,
+		"rows":       rows,
+		"elapsed_ms": float64(elapsed.Microseconds()) / 1000,
+	}
+	if err != nil {
+		fields["details"] = err.Error()
+	}
+	msg := "Query"
+	if slow {
+		msg = "Slow query"
+	}
+	entry.WithFields(fields).Log(level, msg)
+}
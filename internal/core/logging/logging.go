@@ -0,0 +1,43 @@
+// Package logging hands out the logger of the request being served, so that
+// every entry the handlers, services and repositories write about a request
+// carries its ID, route, caller and latency.
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type contextKey struct{}
+
+type requestLogger struct {
+	entry *logrus.Entry
+	start time.Time
+}
+
+// NewContext starts the log of a request that began at start.
+func NewContext(ctx context.Context, entry *logrus.Entry, start time.Time) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLogger{entry: entry, start: start})
+}
+
+// WithFields adds fields to every entry logged about the request in ctx from
+// now on.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	rl, ok := ctx.Value(contextKey{}).(*requestLogger)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, &requestLogger{entry: rl.entry.WithFields(fields), start: rl.start})
+}
+
+// FromContext is the logger of the request in ctx, with the latency so far;
+// outside of requests it is the standard logger.
+func FromContext(ctx context.Context) *logrus.Entry {
+	rl, ok := ctx.Value(contextKey{}).(*requestLogger)
+	if !ok {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return rl.entry.WithField("latency_ms", float64(time.Since(rl.start).Microseconds())/1000)
+}
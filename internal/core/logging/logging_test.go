@@ -0,0 +1,154 @@
+package logging_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/api/middleware"
+	"github.com/Owouwun/effectivemobiletest/internal/core/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// captureStandardLogger records what the standard logger, which RequestID
+// builds the request loggers from, writes at every level.
+func captureStandardLogger(t *testing.T) *logrustest.Hook {
+	t.Helper()
+
+	std := logrus.StandardLogger()
+	output, level := std.Out, std.GetLevel()
+	hook := logrustest.NewGlobal()
+	std.SetOutput(io.Discard)
+	std.SetLevel(logrus.DebugLevel)
+	t.Cleanup(func() {
+		std.ReplaceHooks(make(logrus.LevelHooks))
+		std.SetOutput(output)
+		std.SetLevel(level)
+	})
+
+	return hook
+}
+
+func findEntry(hook *logrustest.Hook, msg string) *logrus.Entry {
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == msg {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestRequestsLogWithRequestFields(t *testing.T) {
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.AccessLog("/healthz"))
+	router.GET("/service/:id", func(c *gin.Context) {
+		ctx := logging.WithFields(c.Request.Context(), logrus.Fields{"user": "alice"})
+		c.Request = c.Request.WithContext(ctx)
+		logging.FromContext(ctx).Info("Handled")
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name      string
+		path      string
+		wantRoute string
+		wantCode  int
+		wantLevel logrus.Level
+	}{
+		{"handled", "/service/42", "/service/:id", http.StatusOK, logrus.InfoLevel},
+		{"quiet route", "/healthz", "/healthz", http.StatusOK, logrus.DebugLevel},
+		{"unmatched", "/nowhere", "unmatched", http.StatusNotFound, logrus.InfoLevel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := captureStandardLogger(t)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("X-Request-ID", "req-1")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			served := findEntry(hook, "Request served")
+			if served == nil {
+				t.Fatalf("the request was not logged: %v", hook.AllEntries())
+			}
+			if served.Level != tt.wantLevel {
+				t.Errorf("got level %s, want %s", served.Level, tt.wantLevel)
+			}
+			want := logrus.Fields{
+				"request_id": "req-1",
+				"method":     http.MethodGet,
+				"route":      tt.wantRoute,
+				"path":       tt.path,
+				"status":     tt.wantCode,
+			}
+			for key, value := range want {
+				if served.Data[key] != value {
+					t.Errorf("access log %s: got %v, want %v", key, served.Data[key], value)
+				}
+			}
+			if served.Data["latency_ms"] == nil || served.Data["client_ip"] == nil {
+				t.Errorf("access log lacks the latency or the client: %v", served.Data)
+			}
+
+			if tt.wantRoute != "/service/:id" {
+				return
+			}
+			if served.Data["size"] != rec.Body.Len() {
+				t.Errorf("access log size: got %v, want %d", served.Data["size"], rec.Body.Len())
+			}
+			handled := findEntry(hook, "Handled")
+			if handled == nil {
+				t.Fatal("the handler did not log")
+			}
+			for _, entry := range []*logrus.Entry{handled, served} {
+				if entry.Data["request_id"] != "req-1" || entry.Data["route"] != "/service/:id" || entry.Data["user"] != "alice" {
+					t.Errorf("entry %q lacks the request fields: %v", entry.Message, entry.Data)
+				}
+			}
+		})
+	}
+}
+
+func TestQueriesLogWithRequestFields(t *testing.T) {
+	logger, hook := logrustest.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	ctx := logging.NewContext(context.Background(), logger.WithField("request_id", "req-1"), time.Now())
+	ctx = logging.WithFields(ctx, logrus.Fields{"user": "alice"})
+
+	gormLogger := logging.NewGormLogger(time.Second)
+	query := func() (string, int64) { return "SELECT * FROM services", 3 }
+	gormLogger.Trace(ctx, time.Now(), query, nil)
+	gormLogger.Trace(ctx, time.Now().Add(-2*time.Second), query, nil)
+
+	entries := hook.AllEntries()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Message != "Query" || entries[0].Level != logrus.DebugLevel {
+		t.Errorf("fast query: got %q at %s, want Query at debug", entries[0].Message, entries[0].Level)
+	}
+	if entries[1].Message != "Slow query" || entries[1].Level != logrus.WarnLevel {
+		t.Errorf("slow query: got %q at %s, want Slow query at warning", entries[1].Message, entries[1].Level)
+	}
+	for _, entry := range entries {
+		if entry.Data["request_id"] != "req-1" || entry.Data["user"] != "alice" || entry.Data["latency_ms"] == nil {
+			t.Errorf("entry %q lacks the request fields: %v", entry.Message, entry.Data)
+		}
+		if entry.Data["sql"] != "SELECT * FROM services" || entry.Data["rows"] != int64(3) {
+			t.Errorf("entry %q lacks the query: %v", entry.Message, entry.Data)
+		}
+	}
+
+	// Without the request the entries still reach the standard logger.
+	if entry := logging.FromContext(context.Background()); entry.Logger != logrus.StandardLogger() || entry.Data["latency_ms"] != nil {
+		t.Errorf("outside of requests got %v", entry.Data)
+	}
+}
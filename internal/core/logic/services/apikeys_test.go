@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// memoryAPIKeys keeps the keys by the hash of their secret, as the table does.
+type memoryAPIKeys struct {
+	byHash map[string]*APIKey
+}
+
+func (r *memoryAPIKeys) CreateAPIKey(_ context.Context, key *APIKey, secretHash string) error {
+	key.ID = uuid.New()
+	key.CreatedAt = time.Now()
+	stored := *key
+	r.byHash[secretHash] = &stored
+	return nil
+}
+
+func (r *memoryAPIKeys) GetAPIKeys(context.Context) ([]*APIKey, error) {
+	keys := make([]*APIKey, 0, len(r.byHash))
+	for _, key := range r.byHash {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (r *memoryAPIKeys) GetAPIKeyByHash(_ context.Context, secretHash string) (*APIKey, error) {
+	key, ok := r.byHash[secretHash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return key, nil
+}
+
+func (r *memoryAPIKeys) RevokeAPIKey(_ context.Context, ID uuid.UUID) error {
+	for _, key := range r.byHash {
+		if key.ID == ID {
+			now := time.Now()
+			key.RevokedAt = &now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func TestAPIKeysAuthenticateUntilRevoked(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryAPIKeys{byHash: make(map[string]*APIKey)}
+	keyService := NewAPIKeyService(repo)
+
+	key := &APIKey{Name: "billing-export", Scopes: []Scope{ScopeCumulate, ScopeRead, ScopeRead}}
+	plaintext, err := keyService.CreateAPIKey(ctx, key)
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	if !strings.HasPrefix(plaintext, apiKeyPrefix) || !strings.HasPrefix(plaintext, key.Prefix) || len(key.Prefix) != apiKeyShownChars {
+		t.Errorf("key %q does not start with its prefix %q", plaintext, key.Prefix)
+	}
+	for hash := range repo.byHash {
+		if hash != hashAPIKey(plaintext) || strings.Contains(hash, plaintext[len(apiKeyPrefix):]) {
+			t.Errorf("stored %q instead of the hash of the key", hash)
+		}
+	}
+	if hashAPIKey(plaintext) == hashAPIKey(plaintext+"x") {
+		t.Error("different keys hash alike")
+	}
+
+	principal, err := keyService.AuthenticateAPIKey(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey failed: %v", err)
+	}
+	want := &Principal{Subject: key.ID, Role: RoleService, Scopes: []Scope{ScopeCumulate, ScopeRead}}
+	if !reflect.DeepEqual(principal, want) {
+		t.Errorf("got principal %+v, want %+v", principal, want)
+	}
+
+	if _, err := keyService.AuthenticateAPIKey(ctx, plaintext+"x"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("unknown key: got %v, want ErrUnauthenticated", err)
+	}
+
+	if err := keyService.RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if _, err := keyService.AuthenticateAPIKey(ctx, plaintext); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("revoked key: got %v, want ErrUnauthenticated", err)
+	}
+
+	expired := &APIKey{Name: "short-lived", Scopes: []Scope{ScopeRead}}
+	expiredKey, err := keyService.CreateAPIKey(ctx, expired)
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	repo.byHash[hashAPIKey(expiredKey)].ExpiresAt = &past
+	if _, err := keyService.AuthenticateAPIKey(ctx, expiredKey); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expired key: got %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestCreateAPIKeyRejects(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		key     APIKey
+		wantErr error
+	}{
+		{"no name", APIKey{Name: " ", Scopes: []Scope{ScopeRead}}, ErrInvalidAPIKey},
+		{"no scopes", APIKey{Name: "export"}, ErrInvalidScope},
+		{"unknown scope", APIKey{Name: "export", Scopes: []Scope{"admin"}}, ErrInvalidScope},
+		{"expired", APIKey{Name: "export", Scopes: []Scope{ScopeRead}, ExpiresAt: &past}, ErrInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &memoryAPIKeys{byHash: make(map[string]*APIKey)}
+			if _, err := NewAPIKeyService(repo).CreateAPIKey(context.Background(), &tt.key); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+			if len(repo.byHash) != 0 {
+				t.Error("the rejected key was stored")
+			}
+		})
+	}
+}
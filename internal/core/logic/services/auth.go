@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+	// RoleService is the role of API key clients; they access the services of
+	// every user within the scopes of their key.
+	RoleService Role = "service"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case "":
+		return RoleUser, nil
+	case RoleUser, RoleAdmin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("%w: unknown role %q", ErrUnauthenticated, s)
+	}
+}
+
+// Principal is the authenticated caller. Users only see their own services.
+// For API key clients Subject is the key ID.
+type Principal struct {
+	Subject uuid.UUID
+	Role    Role
+	Scopes  []Scope
+}
+
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// HasScope reports whether the caller may use routes of the scope. Scopes only
+// restrict API key clients; users are limited by ownership instead.
+func (p *Principal) HasScope(scope Scope) bool {
+	return p.Role != RoleService || slices.Contains(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// ownerScope returns the user the caller is restricted to, or nil when the
+// caller may access the services of every user.
+func ownerScope(ctx context.Context) (*uuid.UUID, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if p.IsAdmin() || p.Role == RoleService {
+		return nil, nil
+	}
+	return &p.Subject, nil
+}
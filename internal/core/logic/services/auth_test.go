@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestOwnerScope(t *testing.T) {
+	subject := uuid.New()
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		wantOwner *uuid.UUID
+		wantErr   error
+	}{
+		{"user", &Principal{Subject: subject, Role: RoleUser}, &subject, nil},
+		{"admin", &Principal{Subject: subject, Role: RoleAdmin}, nil, nil},
+		{"API key", &Principal{Subject: subject, Role: RoleService, Scopes: []Scope{ScopeRead}}, nil, nil},
+		{"anonymous", nil, nil, ErrUnauthenticated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = WithPrincipal(ctx, tt.principal)
+			}
+
+			owner, err := ownerScope(ctx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if (owner == nil) != (tt.wantOwner == nil) || owner != nil && *owner != *tt.wantOwner {
+				t.Errorf("got owner %v, want %v", owner, tt.wantOwner)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+type BatchOp string
+
+const (
+	BatchOpCreate BatchOp = "create"
+	BatchOpUpdate BatchOp = "update"
+	BatchOpDelete BatchOp = "delete"
+)
+
+func ParseBatchOp(s string) (BatchOp, error) {
+	switch op := BatchOp(s); op {
+	case BatchOpCreate, BatchOpUpdate, BatchOpDelete:
+		return op, nil
+	default:
+		return "", fmt.Errorf("%w: unknown operation %q", ErrInvalidBatch, s)
+	}
+}
+
+type BatchMode string
+
+const (
+	// BatchAtomic applies every operation or none.
+	BatchAtomic BatchMode = "atomic"
+	// BatchBestEffort applies every operation that succeeds on its own.
+	BatchBestEffort BatchMode = "best_effort"
+)
+
+func ParseBatchMode(s string) (BatchMode, error) {
+	switch mode := BatchMode(s); mode {
+	case "":
+		return BatchAtomic, nil
+	case BatchAtomic, BatchBestEffort:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%w: unknown mode %q", ErrInvalidBatch, s)
+	}
+}
+
+// MaxBatchSize is the most operations a batch may hold.
+const MaxBatchSize = 100
+
+// BatchOperation creates, updates or deletes Service the way the single
+// operations do. An update applies Patch to the service with the ID of
+// Service, a delete only needs the service ID.
+type BatchOperation struct {
+	Op      BatchOp
+	Service *Service
+	Patch   func(srv *Service) error
+}
+
+// ApplyBatch applies the operations in order and returns the error of each,
+// nil for those that succeeded. In atomic mode the first failure rolls back
+// the operations before it, which then fail with ErrBatchAborted along with
+// all the operations after it.
+func (s *SubscriptionService) ApplyBatch(ctx context.Context, ops []*BatchOperation, mode BatchMode) ([]error, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.ApplyBatch")
+	defer span.End()
+
+	if len(ops) > MaxBatchSize {
+		return nil, fmt.Errorf("%w: more than %d operations", ErrInvalidBatch, MaxBatchSize)
+	}
+
+	errs := make([]error, len(ops))
+	if mode == BatchBestEffort {
+		for i, op := range ops {
+			errs[i] = s.applyBatchOperation(ctx, op)
+		}
+		return errs, nil
+	}
+
+	failed := -1
+	err := s.repo.Transaction(ctx, func(ctx context.Context) error {
+		for i, op := range ops {
+			if err := s.applyBatchOperation(ctx, op); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if failed < 0 {
+			return nil, err
+		}
+		for i := range errs {
+			errs[i] = ErrBatchAborted
+		}
+		errs[failed] = err
+	}
+
+	return errs, nil
+}
+
+func (s *SubscriptionService) applyBatchOperation(ctx context.Context, op *BatchOperation) error {
+	switch op.Op {
+	case BatchOpCreate:
+		return s.CreateService(ctx, op.Service)
+	case BatchOpUpdate:
+		srv, err := s.PatchService(ctx, op.Service.ID, op.Service.Version, op.Patch)
+		if err != nil {
+			return err
+		}
+		*op.Service = *srv
+		return nil
+	case BatchOpDelete:
+		return s.DeleteService(ctx, op.Service.ID, op.Service.Version)
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidBatch, op.Op)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EndlessDate stands for the missing end date of a service in a cursor; such
+// services sort after all the others.
+const EndlessDate = "infinity"
+
+// Cursor points at a row of a keyset-paginated list. Values hold the row
+// values of the query OrderKeys in order, dates as 2006-01-02.
+type Cursor struct {
+	Sort     string    `json:"s"`
+	Values   []string  `json:"v"`
+	ID       uuid.UUID `json:"id"`
+	Backward bool      `json:"b,omitempty"`
+}
+
+// Encode returns the opaque form of the cursor handed out to clients.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+
+	return &c, nil
+}
+
+// validFor reports whether the cursor was handed out for a query ordered like
+// this one.
+func (c *Cursor) validFor(query *ListQuery) error {
+	if c.Sort != FormatSort(query.Sort) {
+		return fmt.Errorf("%w: issued for sort %q", ErrInvalidCursor, c.Sort)
+	}
+
+	keys := query.OrderKeys()
+	if len(c.Values) != len(keys) {
+		return fmt.Errorf("%w: expected %d values, got %d", ErrInvalidCursor, len(keys), len(c.Values))
+	}
+
+	for i, key := range keys {
+		value := c.Values[i]
+		var err error
+		switch key.Field {
+		case SortByPrice:
+			_, err = strconv.ParseInt(value, 10, 64)
+		case SortByStartDate:
+			_, err = time.Parse(time.DateOnly, value)
+		case SortByEndDate:
+			if value != EndlessDate {
+				_, err = time.Parse(time.DateOnly, value)
+			}
+		case SortByUserID:
+			_, err = uuid.Parse(value)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: bad %s value %q", ErrInvalidCursor, key.Field, value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrorKind is the class of a domain error; the API maps every kind to one
+// HTTP status.
+type ErrorKind string
+
+const (
+	KindInternal        ErrorKind = "internal"
+	KindInvalid         ErrorKind = "invalid"
+	KindUnauthenticated ErrorKind = "unauthenticated"
+	KindForbidden       ErrorKind = "forbidden"
+	KindNotFound        ErrorKind = "not_found"
+	KindConflict        ErrorKind = "conflict"
+	KindPrecondition    ErrorKind = "precondition"
+	KindValidation      ErrorKind = "validation"
+	KindAborted         ErrorKind = "aborted"
+)
+
+// Error is a domain error. Code names it for good and is safe to show to
+// the clients, as is the message of every error that wraps it.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	message string
+}
+
+func newError(kind ErrorKind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, message: message}
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+// Title is the message as a sentence title.
+func (e *Error) Title() string {
+	r, size := utf8.DecodeRuneInString(e.message)
+	return string(unicode.ToUpper(r)) + e.message[size:]
+}
+
+// AsError finds the domain error in the chain of err.
+func AsError(err error) (*Error, bool) {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr, true
+	}
+	return nil, false
+}
+
+// KindOf is KindInternal for the errors that are not domain errors.
+func KindOf(err error) ErrorKind {
+	if domainErr, ok := AsError(err); ok {
+		return domainErr.Kind
+	}
+	return KindInternal
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyRecord remembers the request made with an idempotency key and,
+// once the request completed, the response to replay. Keys are scoped to the
+// caller.
+type IdempotencyRecord struct {
+	Key         string
+	Subject     uuid.UUID
+	RequestHash string
+	// Status is zero while the request is still in progress.
+	Status    int
+	Response  []byte
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type IdempotencyRepository interface {
+	// ClaimIdempotencyKey stores rec unless an unexpired record of the key
+	// exists; it returns that record then, or nil when rec was stored.
+	ClaimIdempotencyKey(ctx context.Context, rec *IdempotencyRecord) (*IdempotencyRecord, error)
+	CompleteIdempotencyKey(ctx context.Context, rec *IdempotencyRecord) error
+	ReleaseIdempotencyKey(ctx context.Context, subject uuid.UUID, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error)
+}
+
+// idempotencyPurgeInterval is how often the expired keys are deleted.
+const idempotencyPurgeInterval = time.Hour
+
+const MaxIdempotencyKeyLength = 255
+
+type IdempotencyService struct {
+	repo IdempotencyRepository
+	ttl  time.Duration
+
+	mu         sync.Mutex
+	lastPurged time.Time
+}
+
+func NewIdempotencyService(repo IdempotencyRepository, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{
+		repo: repo,
+		ttl:  ttl,
+	}
+}
+
+func hashIdempotentRequest(method, path string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", method, path)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// BeginRequest claims the key for the request of the caller. It returns the
+// record to replay when the same request was already made with the key,
+// ErrIdempotencyKeyReused when a different one was and
+// ErrIdempotencyInProgress when the first request has not completed yet.
+func (s *IdempotencyService) BeginRequest(ctx context.Context, key, method, path string, body []byte) (*IdempotencyRecord, error) {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, ErrUnauthenticated
+	}
+	if key == "" || len(key) > MaxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%w: must be 1 to %d characters", ErrInvalidIdempotencyKey, MaxIdempotencyKeyLength)
+	}
+
+	now := time.Now()
+	s.purgeExpired(ctx, now)
+
+	rec := &IdempotencyRecord{
+		Key:         key,
+		Subject:     p.Subject,
+		RequestHash: hashIdempotentRequest(method, path, body),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	existing, err := s.repo.ClaimIdempotencyKey(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case existing == nil:
+		return nil, nil
+	case existing.RequestHash != rec.RequestHash:
+		return nil, ErrIdempotencyKeyReused
+	case existing.Status == 0:
+		return nil, ErrIdempotencyInProgress
+	default:
+		return existing, nil
+	}
+}
+
+// CompleteRequest stores the response to replay for the key.
+func (s *IdempotencyService) CompleteRequest(ctx context.Context, key string, status int, response []byte) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	return s.repo.CompleteIdempotencyKey(ctx, &IdempotencyRecord{
+		Key:      key,
+		Subject:  p.Subject,
+		Status:   status,
+		Response: response,
+	})
+}
+
+// ReleaseRequest frees the key of a request that failed so that it can be
+// retried.
+func (s *IdempotencyService) ReleaseRequest(ctx context.Context, key string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ErrUnauthenticated
+	}
+
+	return s.repo.ReleaseIdempotencyKey(ctx, p.Subject, key)
+}
+
+func (s *IdempotencyService) purgeExpired(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPurged) < idempotencyPurgeInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurged = now
+	s.mu.Unlock()
+
+	// Expired keys are reclaimed anyway; purging only keeps the table small,
+	// so a failure is not the caller's problem.
+	_, _ = s.repo.DeleteExpiredIdempotencyKeys(ctx, now)
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxImportRows is the most services an import may hold.
+const MaxImportRows = 10000
+
+// ImportServices checks that the caller may create every service and, on
+// commit, creates them all in one transaction. It returns the error of each
+// service, nil for the valid ones; nothing is created unless all are valid.
+func (s *SubscriptionService) ImportServices(ctx context.Context, srvs []*Service, commit bool) ([]error, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.ImportServices")
+	defer span.End()
+
+	if len(srvs) > MaxImportRows {
+		return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidImport, MaxImportRows)
+	}
+
+	errs := make([]error, len(srvs))
+	valid := true
+	for i, srv := range srvs {
+		if errs[i] = prepareNewService(ctx, srv); errs[i] != nil {
+			valid = false
+		}
+	}
+	if !commit || !valid {
+		return errs, nil
+	}
+
+	err := s.repo.Transaction(ctx, func(ctx context.Context) error {
+		for i, srv := range srvs {
+			if err := s.repo.CreateService(ctx, srv); err != nil {
+				return fmt.Errorf("service %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return errs, nil
+}
@@ -1,6 +1,10 @@
 package services
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 func minDate(date1, date2 time.Time) time.Time {
 	if date1.Before(date2) {
@@ -25,3 +29,169 @@ func monthsBetween(startDate, endDate time.Time) int {
 	months := int(endDate.Month()) - int(startDate.Month())
 	return years*12 + months
 }
+
+func monthStart(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// addMonths moves the date by the months, keeping its day but no later than
+// the last day of the target month.
+func addMonths(date time.Time, months int) time.Time {
+	target := time.Date(date.Year(), date.Month()+time.Month(months), 1, date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), date.Location())
+	lastDay := target.AddDate(0, 1, -1).Day()
+	return target.AddDate(0, 0, min(date.Day(), lastDay)-1)
+}
+
+// chargeDate returns the n-th charge of the service, always counted from its
+// start date so that a charge clamped to a short month does not shift the
+// following ones.
+func chargeDate(srv *Service, n int) time.Time {
+	periods := n * max(srv.BillingInterval, 1)
+	switch srv.BillingPeriod {
+	case BillingPeriodWeek:
+		return srv.StartDate.AddDate(0, 0, 7*periods)
+	case BillingPeriodQuarter:
+		return addMonths(srv.StartDate, 3*periods)
+	case BillingPeriodYear:
+		return addMonths(srv.StartDate, 12*periods)
+	default:
+		return addMonths(srv.StartDate, periods)
+	}
+}
+
+// chargeDates returns the dates the service is charged at inside the filters
+// window.
+func chargeDates(srv *Service, filters *Filters) []time.Time {
+	start := maxDate(filters.StartDate, srv.StartDate)
+	end := filters.EndDate
+	if srv.EndDate != nil {
+		end = minDate(filters.EndDate, *srv.EndDate)
+	}
+
+	var dates []time.Time
+	for n := 0; ; n++ {
+		date := chargeDate(srv, n)
+		if !date.Before(end) {
+			break
+		}
+		if !date.Before(start) {
+			dates = append(dates, date)
+		}
+	}
+
+	return dates
+}
+
+// priceAt returns the price in effect at the given date.
+func priceAt(srv *Service, date time.Time) Money {
+	price := srv.Price
+	for _, change := range srv.Prices {
+		if change.EffectiveFrom.After(date) {
+			break
+		}
+		price = change.Price
+	}
+	return price
+}
+
+// AggregateCosts is the in-memory reference for SubscriptionRepository.AggregateCosts:
+// it sums the prices in effect at every charge of already filtered services per
+// month, service name, user and currency.
+func AggregateCosts(srvs []*Service, filters *Filters) ([]*CostEntry, error) {
+	type costKey struct {
+		month       time.Time
+		serviceName string
+		userID      uuid.UUID
+		currency    string
+	}
+
+	var entries []*CostEntry
+	index := make(map[costKey]*CostEntry)
+	for _, srv := range srvs {
+		for _, date := range chargeDates(srv, filters) {
+			price := priceAt(srv, date)
+			key := costKey{
+				month:       monthStart(date),
+				serviceName: srv.ServiceName,
+				userID:      srv.UserID,
+				currency:    price.Currency,
+			}
+			entry, ok := index[key]
+			if !ok {
+				entry = &CostEntry{
+					Month:       key.month,
+					ServiceName: key.serviceName,
+					UserID:      key.userID,
+					Amount:      Money{Currency: key.currency},
+				}
+				index[key] = entry
+				entries = append(entries, entry)
+			}
+
+			var err error
+			if entry.Amount, err = entry.Amount.Add(price); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// breakdownCosts spreads the entries over the months the filters window
+// touches, so that the months add up to the cumulated total; a window ending
+// inside a month includes it.
+func breakdownCosts(entries []*CostEntry, filters *Filters) ([]*MonthlyCost, error) {
+	months := make([]*MonthlyCost, 0)
+	if !filters.StartDate.Before(filters.EndDate) {
+		return months, nil
+	}
+
+	first := monthStart(filters.StartDate)
+	count := monthsBetween(filters.StartDate, filters.EndDate)
+	if !monthStart(filters.EndDate).Equal(filters.EndDate) {
+		count++
+	}
+	index := make(map[time.Time]*MonthlyCost)
+	for i := 0; i < count; i++ {
+		month := &MonthlyCost{
+			Month:     first.AddDate(0, i, 0),
+			Total:     Money{Currency: filters.TargetCurrency},
+			ByService: make(map[string]Money),
+			ByUser:    make(map[uuid.UUID]Money),
+		}
+		index[month.Month] = month
+		months = append(months, month)
+	}
+
+	zero := Money{Currency: filters.TargetCurrency}
+	for _, entry := range entries {
+		month, ok := index[monthStart(entry.Month)]
+		if !ok {
+			continue
+		}
+
+		var err error
+		if month.Total, err = month.Total.Add(entry.Amount); err != nil {
+			return nil, err
+		}
+
+		byService, ok := month.ByService[entry.ServiceName]
+		if !ok {
+			byService = zero
+		}
+		if month.ByService[entry.ServiceName], err = byService.Add(entry.Amount); err != nil {
+			return nil, err
+		}
+
+		byUser, ok := month.ByUser[entry.UserID]
+		if !ok {
+			byUser = zero
+		}
+		if month.ByUser[entry.UserID], err = byUser.Add(entry.Amount); err != nil {
+			return nil, err
+		}
+	}
+
+	return months, nil
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestChargeDates(t *testing.T) {
+	ended := date(2024, time.March, 15)
+
+	tests := []struct {
+		name    string
+		srv     Service
+		filters Filters
+		want    []time.Time
+	}{
+		{
+			name:    "monthly",
+			srv:     Service{StartDate: date(2024, time.January, 15)},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.April, 1)},
+			want:    []time.Time{date(2024, time.January, 15), date(2024, time.February, 15), date(2024, time.March, 15)},
+		},
+		{
+			name:    "weekly from before the window",
+			srv:     Service{StartDate: date(2024, time.January, 29), BillingPeriod: BillingPeriodWeek},
+			filters: Filters{StartDate: date(2024, time.February, 1), EndDate: date(2024, time.March, 1)},
+			want:    []time.Time{date(2024, time.February, 5), date(2024, time.February, 12), date(2024, time.February, 19), date(2024, time.February, 26)},
+		},
+		{
+			name:    "quarterly",
+			srv:     Service{StartDate: date(2023, time.November, 15), BillingPeriod: BillingPeriodQuarter, BillingInterval: 1},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.July, 1)},
+			want:    []time.Time{date(2024, time.February, 15), date(2024, time.May, 15)},
+		},
+		{
+			name:    "every other year",
+			srv:     Service{StartDate: date(2022, time.June, 1), BillingPeriod: BillingPeriodYear, BillingInterval: 2},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2026, time.January, 1)},
+			want:    []time.Time{date(2024, time.June, 1)},
+		},
+		{
+			name:    "not charged on the end date",
+			srv:     Service{StartDate: date(2024, time.January, 15), EndDate: &ended},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.June, 1)},
+			want:    []time.Time{date(2024, time.January, 15), date(2024, time.February, 15)},
+		},
+		{
+			name:    "monthly from the 31st",
+			srv:     Service{StartDate: date(2024, time.January, 31)},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.June, 1)},
+			want:    []time.Time{date(2024, time.January, 31), date(2024, time.February, 29), date(2024, time.March, 31), date(2024, time.April, 30), date(2024, time.May, 31)},
+		},
+		{
+			name:    "monthly from the 30th across a common February",
+			srv:     Service{StartDate: date(2023, time.January, 30)},
+			filters: Filters{StartDate: date(2023, time.January, 1), EndDate: date(2023, time.April, 1)},
+			want:    []time.Time{date(2023, time.January, 30), date(2023, time.February, 28), date(2023, time.March, 30)},
+		},
+		{
+			name:    "monthly from the 29th across a leap February",
+			srv:     Service{StartDate: date(2024, time.January, 29)},
+			filters: Filters{StartDate: date(2024, time.February, 1), EndDate: date(2024, time.April, 1)},
+			want:    []time.Time{date(2024, time.February, 29), date(2024, time.March, 29)},
+		},
+		{
+			name:    "quarterly from the 30th",
+			srv:     Service{StartDate: date(2023, time.November, 30), BillingPeriod: BillingPeriodQuarter},
+			filters: Filters{StartDate: date(2023, time.November, 1), EndDate: date(2024, time.September, 1)},
+			want:    []time.Time{date(2023, time.November, 30), date(2024, time.February, 29), date(2024, time.May, 30), date(2024, time.August, 30)},
+		},
+		{
+			name:    "quarterly from the 31st",
+			srv:     Service{StartDate: date(2024, time.August, 31), BillingPeriod: BillingPeriodQuarter},
+			filters: Filters{StartDate: date(2024, time.August, 1), EndDate: date(2025, time.June, 1)},
+			want:    []time.Time{date(2024, time.August, 31), date(2024, time.November, 30), date(2025, time.February, 28), date(2025, time.May, 31)},
+		},
+		{
+			name:    "yearly from a leap day",
+			srv:     Service{StartDate: date(2024, time.February, 29), BillingPeriod: BillingPeriodYear},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2029, time.January, 1)},
+			want:    []time.Time{date(2024, time.February, 29), date(2025, time.February, 28), date(2026, time.February, 28), date(2027, time.February, 28), date(2028, time.February, 29)},
+		},
+		{
+			name:    "starts after the window",
+			srv:     Service{StartDate: date(2024, time.June, 1)},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.June, 1)},
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chargeDates(&tt.srv, &tt.filters)
+			if !slices.EqualFunc(got, tt.want, time.Time.Equal) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// withPrices gives srv the price history of the amounts in RUB, each taking
+// effect at its month, and the last of them as its current price.
+func withPrices(srv Service, amounts map[time.Time]int64) *Service {
+	months := make([]time.Time, 0, len(amounts))
+	for month := range amounts {
+		months = append(months, month)
+	}
+	slices.SortFunc(months, time.Time.Compare)
+
+	for _, month := range months {
+		srv.Price = Money{Amount: amounts[month], Currency: "RUB"}
+		srv.Prices = append(srv.Prices, &PriceChange{ServiceID: srv.ID, EffectiveFrom: month, Price: srv.Price})
+	}
+	return &srv
+}
+
+func TestBreakdownCostsWithPriceChanges(t *testing.T) {
+	userID := uuid.New()
+
+	tests := []struct {
+		name    string
+		srv     *Service
+		filters Filters
+		want    []int64
+	}{
+		{
+			name: "monthly",
+			srv: withPrices(Service{StartDate: date(2024, time.January, 15)}, map[time.Time]int64{
+				date(2024, time.January, 1): 1000,
+				date(2024, time.March, 1):   1500,
+			}),
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.May, 1)},
+			want:    []int64{1000, 1000, 1500, 1500},
+		},
+		{
+			name: "weekly",
+			srv: withPrices(Service{StartDate: date(2024, time.January, 1), BillingPeriod: BillingPeriodWeek}, map[time.Time]int64{
+				date(2024, time.January, 1):  100,
+				date(2024, time.February, 1): 200,
+			}),
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.March, 1)},
+			want:    []int64{5 * 100, 4 * 200},
+		},
+		{
+			name: "change inside a quarter applies from the next charge",
+			srv: withPrices(Service{StartDate: date(2024, time.January, 10), BillingPeriod: BillingPeriodQuarter}, map[time.Time]int64{
+				date(2024, time.January, 1):  3000,
+				date(2024, time.February, 1): 3600,
+			}),
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.June, 1)},
+			want:    []int64{3000, 0, 0, 3600, 0},
+		},
+		{
+			name: "window after a change",
+			srv: withPrices(Service{StartDate: date(2023, time.November, 20)}, map[time.Time]int64{
+				date(2023, time.November, 1): 500,
+				date(2024, time.January, 1):  700,
+				date(2024, time.April, 1):    900,
+			}),
+			filters: Filters{StartDate: date(2024, time.February, 1), EndDate: date(2024, time.May, 1)},
+			want:    []int64{700, 700, 900},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.srv.ServiceName = "Yandex Plus"
+			tt.srv.UserID = userID
+			tt.filters.TargetCurrency = "RUB"
+
+			entries, err := AggregateCosts([]*Service{tt.srv}, &tt.filters)
+			if err != nil {
+				t.Fatalf("AggregateCosts failed: %v", err)
+			}
+			months, err := breakdownCosts(entries, &tt.filters)
+			if err != nil {
+				t.Fatalf("breakdownCosts failed: %v", err)
+			}
+
+			if len(months) != len(tt.want) {
+				t.Fatalf("got %d months, want %d", len(months), len(tt.want))
+			}
+			for i, month := range months {
+				if want := tt.filters.StartDate.AddDate(0, i, 0); !month.Month.Equal(want) {
+					t.Errorf("month %d: got %s, want %s", i, month.Month, want)
+				}
+				if month.Total.Amount != tt.want[i] || month.Total.Currency != "RUB" {
+					t.Errorf("%s: got total %d %s, want %d RUB", month.Month.Format("01-2006"), month.Total.Amount, month.Total.Currency, tt.want[i])
+				}
+				if tt.want[i] != 0 && (month.ByService["Yandex Plus"] != month.Total || month.ByUser[userID] != month.Total) {
+					t.Errorf("%s: subtotals %v and %v do not match the total", month.Month.Format("01-2006"), month.ByService, month.ByUser)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakdownCostsMatchesCumulation(t *testing.T) {
+	alice, bob := uuid.New(), uuid.New()
+	monthly := func(name string, userID uuid.UUID, amount int64, start time.Time, end *time.Time) *Service {
+		return &Service{
+			ServiceName: name,
+			UserID:      userID,
+			Price:       Money{Amount: amount, Currency: "RUB"},
+			StartDate:   start,
+			EndDate:     end,
+		}
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name    string
+		srvs    []*Service
+		filters Filters
+		want    []int64
+	}{
+		{
+			name:    "starts and ends inside the window",
+			srvs:    []*Service{monthly("Yandex Plus", alice, 1000, date(2024, time.February, 20), ptr(date(2024, time.April, 20)))},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.June, 1)},
+			want:    []int64{0, 1000, 1000, 0, 0},
+		},
+		{
+			name:    "open-ended",
+			srvs:    []*Service{monthly("Yandex Plus", alice, 1000, date(2023, time.June, 10), nil)},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.April, 1)},
+			want:    []int64{1000, 1000, 1000},
+		},
+		{
+			name:    "charged on the 31st",
+			srvs:    []*Service{monthly("Yandex Plus", alice, 1000, date(2024, time.January, 31), nil)},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.May, 1)},
+			want:    []int64{1000, 1000, 1000, 1000},
+		},
+		{
+			name:    "window starts and ends inside a month",
+			srvs:    []*Service{monthly("Yandex Plus", alice, 1000, date(2024, time.January, 5), nil)},
+			filters: Filters{StartDate: date(2024, time.January, 10), EndDate: date(2024, time.April, 10)},
+			want:    []int64{0, 1000, 1000, 1000},
+		},
+		{
+			name: "services of several users",
+			srvs: []*Service{
+				monthly("Yandex Plus", alice, 1000, date(2024, time.January, 1), nil),
+				monthly("Kinopoisk", bob, 500, date(2024, time.February, 15), ptr(date(2024, time.March, 1))),
+			},
+			filters: Filters{StartDate: date(2024, time.January, 1), EndDate: date(2024, time.April, 1)},
+			want:    []int64{1000, 1500, 1000},
+		},
+		{
+			name:    "empty window",
+			srvs:    []*Service{monthly("Yandex Plus", alice, 1000, date(2024, time.January, 1), nil)},
+			filters: Filters{StartDate: date(2024, time.March, 1), EndDate: date(2024, time.March, 1)},
+			want:    []int64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filters.TargetCurrency = "RUB"
+			entries, err := AggregateCosts(tt.srvs, &tt.filters)
+			if err != nil {
+				t.Fatalf("AggregateCosts failed: %v", err)
+			}
+			months, err := breakdownCosts(entries, &tt.filters)
+			if err != nil {
+				t.Fatalf("breakdownCosts failed: %v", err)
+			}
+
+			got := make([]int64, 0, len(months))
+			var monthsTotal, byServiceTotal, byUserTotal, cumulated int64
+			for _, month := range months {
+				got = append(got, month.Total.Amount)
+				monthsTotal += month.Total.Amount
+				for _, amount := range month.ByService {
+					byServiceTotal += amount.Amount
+				}
+				for _, amount := range month.ByUser {
+					byUserTotal += amount.Amount
+				}
+			}
+			for _, entry := range entries {
+				cumulated += entry.Amount.Amount
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got months %v, want %v", got, tt.want)
+			}
+			if monthsTotal != cumulated || byServiceTotal != cumulated || byUserTotal != cumulated {
+				t.Errorf("months add up to %d, by service to %d and by user to %d, want the cumulated %d", monthsTotal, byServiceTotal, byUserTotal, cumulated)
+			}
+		})
+	}
+}
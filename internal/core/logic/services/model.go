@@ -1,19 +1,77 @@
 package services
 
 import (
-	"errors"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type BillingPeriod string
+
+const (
+	BillingPeriodWeek    BillingPeriod = "week"
+	BillingPeriodMonth   BillingPeriod = "month"
+	BillingPeriodQuarter BillingPeriod = "quarter"
+	BillingPeriodYear    BillingPeriod = "year"
+)
+
+func ParseBillingPeriod(s string) (BillingPeriod, error) {
+	switch period := BillingPeriod(s); period {
+	case BillingPeriodWeek, BillingPeriodMonth, BillingPeriodQuarter, BillingPeriodYear:
+		return period, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidBillingPeriod, s)
+	}
+}
+
+const DefaultCurrency = "RUB"
+
+// ParseCurrency accepts an ISO 4217 alphabetic code in any case.
+func ParseCurrency(s string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(s))
+	if len(code) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, s)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("%w: %q", ErrInvalidCurrency, s)
+		}
+	}
+	return code, nil
+}
+
 type Service struct {
 	ID          uuid.UUID  `json:"id,omitempty" example:"123e4567-e89b-12d3-a456-426614174009"`
 	ServiceName string     `json:"service_name" example:"My Service"`
-	Price       int        `json:"price" example:"500"`
+	Price       Money      `json:"price"`
 	UserID      uuid.UUID  `json:"user_id" example:"123e4567-e89b-12d3-a456-426614174000"`
 	StartDate   time.Time  `json:"start_date" example:"01-2024"`
 	EndDate     *time.Time `json:"end_date,omitempty" example:"12-2025"`
+
+	// The service is charged at StartDate and then every BillingInterval
+	// billing periods.
+	BillingPeriod   BillingPeriod `json:"billing_period" example:"month"`
+	BillingInterval int           `json:"billing_interval" example:"1"`
+
+	// DeletedAt is set on deleted services until they are restored or purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// Version grows with every change of the service. An update or delete
+	// with a version only applies to that version of the service.
+	Version int64 `json:"version" example:"1"`
+
+	// Prices is the price history starting with the price at StartDate; the
+	// scheduled changes are loaded only where cumulation needs them.
+	Prices []*PriceChange `json:"-"`
+}
+
+type PriceChange struct {
+	ServiceID     uuid.UUID `json:"-"`
+	EffectiveFrom time.Time `json:"effective_from" example:"01-2025"`
+	Price         Money     `json:"price"`
 }
 
 type Filters struct {
@@ -21,8 +79,203 @@ type Filters struct {
 	UserIDs   []*uuid.UUID `json:"user_id,omitempty" example:"[\"123e4567-e89b-12d3-a456-426614174000\"]"`
 	StartDate time.Time    `json:"start_date" example:"01-2024"`
 	EndDate   time.Time    `json:"end_date" example:"12-2025"`
+
+	// TargetCurrency is the currency cumulated costs are converted to.
+	TargetCurrency string `json:"target_currency,omitempty" example:"RUB"`
+}
+
+type SortField string
+
+const (
+	SortByServiceName SortField = "service_name"
+	SortByPrice       SortField = "price"
+	SortByStartDate   SortField = "start_date"
+	SortByEndDate     SortField = "end_date"
+	SortByUserID      SortField = "user_id"
+)
+
+type SortKey struct {
+	Field SortField
+	Desc  bool
+}
+
+// ParseSort parses a comma separated list of fields, each descending when
+// prefixed with "-", like "price,-start_date".
+func ParseSort(s string) ([]SortKey, error) {
+	var keys []SortKey
+	seen := make(map[SortField]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		key := SortKey{Field: SortField(strings.TrimPrefix(part, "-")), Desc: strings.HasPrefix(part, "-")}
+		switch key.Field {
+		case SortByServiceName, SortByPrice, SortByStartDate, SortByEndDate, SortByUserID:
+		default:
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, key.Field)
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("%w: field %q is repeated", ErrInvalidSort, key.Field)
+		}
+		seen[key.Field] = true
+
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// FormatSort is the inverse of ParseSort.
+func FormatSort(keys []SortKey) string {
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		part := string(key.Field)
+		if key.Desc {
+			part = "-" + part
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ListQuery selects a page of services. Unset fields do not filter; the price
+// range applies to the current price in PriceCurrency, ActiveAt to the month a
+// service is active in.
+//
+// Pages are numbered by Page unless Keyset is set: then the page follows, or
+// with Cursor.Backward precedes, the row Cursor points at, and the first page
+// has no Cursor. The total count is only computed with IncludeTotal.
+type ListQuery struct {
+	UserID            *uuid.UUID
+	ServiceName       *string
+	ServiceNamePrefix *string
+	MinPrice          *int64
+	MaxPrice          *int64
+	PriceCurrency     string
+	ActiveAt          *time.Time
+	IncludeDeleted    bool
+
+	Sort []SortKey
+	Page int
+	Size int
+
+	Keyset       bool
+	Cursor       *Cursor
+	IncludeTotal bool
+}
+
+// OrderKeys returns Sort with the start date tie-breaker appended; rows equal
+// on every key are ordered by ID.
+func (q *ListQuery) OrderKeys() []SortKey {
+	keys := append([]SortKey(nil), q.Sort...)
+	for _, key := range q.Sort {
+		if key.Field == SortByStartDate {
+			return keys
+		}
+	}
+	return append(keys, SortKey{Field: SortByStartDate})
+}
+
+// ServicePage is a page of services. TotalCount is nil unless the query asked
+// for it; the cursors are nil when there is no page in their direction.
+type ServicePage struct {
+	Services   []*Service
+	TotalCount *int
+	NextCursor *Cursor
+	PrevCursor *Cursor
+}
+
+type CostEntry struct {
+	Month       time.Time
+	ServiceName string
+	UserID      uuid.UUID
+	Amount      Money
+}
+
+// ServiceGroup counts the services sharing a billing schedule and the
+// currency of their current price.
+type ServiceGroup struct {
+	BillingPeriod   BillingPeriod
+	BillingInterval int
+	Services        int64
+	Amount          Money
+}
+
+// SubscriptionStats sums up the services active in the current month across
+// all users. MonthlyRunRate is what they cost a month in each currency, in
+// major units.
+type SubscriptionStats struct {
+	ActiveServices int64
+	MonthlyRunRate map[string]float64
+}
+
+type MonthlyCost struct {
+	Month     time.Time           `json:"month" example:"01-2024"`
+	Total     Money               `json:"total"`
+	ByService map[string]Money    `json:"by_service"`
+	ByUser    map[uuid.UUID]Money `json:"by_user"`
+}
+
+// ExchangeRate converts FromCurrency to ToCurrency from Month until the next
+// rate for the same pair. Rate is a positive decimal number.
+type ExchangeRate struct {
+	ID           uuid.UUID `json:"id,omitempty" example:"123e4567-e89b-12d3-a456-426614174010"`
+	FromCurrency string    `json:"from_currency" example:"USD"`
+	ToCurrency   string    `json:"to_currency" example:"RUB"`
+	Month        time.Time `json:"month" example:"01-2024"`
+	Rate         string    `json:"rate" example:"89.6883"`
+}
+
+type AuditAction string
+
+const (
+	AuditActionCreate      AuditAction = "create"
+	AuditActionUpdate      AuditAction = "update"
+	AuditActionDelete      AuditAction = "delete"
+	AuditActionPriceChange AuditAction = "price_change"
+	AuditActionRestore     AuditAction = "restore"
+	AuditActionPurge       AuditAction = "purge"
+)
+
+// ServiceAuditEntry records one mutation of a service. Before and After are
+// snapshots of the stored service row with its scheduled prices; Before is
+// absent on create and After on purge.
+type ServiceAuditEntry struct {
+	ID        int64           `json:"id" example:"42"`
+	ServiceID uuid.UUID       `json:"service_id" example:"123e4567-e89b-12d3-a456-426614174009"`
+	UserID    uuid.UUID       `json:"user_id" example:"123e4567-e89b-12d3-a456-426614174000"`
+	ActorID   *uuid.UUID      `json:"actor_id,omitempty" example:"123e4567-e89b-12d3-a456-426614174000"`
+	ActorRole Role            `json:"actor_role,omitempty" example:"user"`
+	Action    AuditAction     `json:"action" example:"update"`
+	Before    json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After     json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
 }
 
 var (
-	ErrNotFound = errors.New("not found")
+	ErrNotFound              = newError(KindNotFound, "not-found", "not found")
+	ErrAlreadyExists         = newError(KindConflict, "already-exists", "already exists")
+	ErrInvalidBillingPeriod  = newError(KindInvalid, "invalid-billing-period", "invalid billing period")
+	ErrInvalidCurrency       = newError(KindInvalid, "invalid-currency", "invalid currency")
+	ErrInvalidRate           = newError(KindInvalid, "invalid-rate", "invalid exchange rate")
+	ErrRateNotFound          = newError(KindValidation, "rate-not-found", "exchange rate not found")
+	ErrInvalidAmount         = newError(KindInvalid, "invalid-amount", "invalid amount")
+	ErrAmountOverflow        = newError(KindValidation, "amount-overflow", "amount overflow")
+	ErrCurrencyMismatch      = newError(KindValidation, "currency-mismatch", "currency mismatch")
+	ErrUnauthenticated       = newError(KindUnauthenticated, "unauthenticated", "unauthenticated")
+	ErrForbidden             = newError(KindForbidden, "forbidden", "forbidden")
+	ErrInvalidScope          = newError(KindInvalid, "invalid-scope", "invalid scope")
+	ErrInvalidAPIKey         = newError(KindInvalid, "invalid-api-key", "invalid API key")
+	ErrInvalidSort           = newError(KindInvalid, "invalid-sort", "invalid sort")
+	ErrInvalidCursor         = newError(KindInvalid, "invalid-cursor", "invalid cursor")
+	ErrInvalidBatch          = newError(KindInvalid, "invalid-batch", "invalid batch")
+	ErrBatchAborted          = newError(KindAborted, "batch-aborted", "batch aborted")
+	ErrInvalidImport         = newError(KindInvalid, "invalid-import", "invalid import")
+	ErrInvalidIdempotencyKey = newError(KindInvalid, "invalid-idempotency-key", "invalid idempotency key")
+	ErrIdempotencyKeyReused  = newError(KindValidation, "idempotency-key-reused", "idempotency key reused with a different request")
+	ErrIdempotencyInProgress = newError(KindConflict, "idempotency-in-progress", "request with the idempotency key in progress")
+	ErrVersionMismatch       = newError(KindPrecondition, "version-mismatch", "version mismatch")
+	ErrValidation            = newError(KindValidation, "validation", "validation failed")
+	ErrInvalidRequest        = newError(KindInvalid, "invalid-request", "invalid request")
 )
@@ -0,0 +1,175 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor units (kopecks, cents) of an ISO 4217 currency.
+// In JSON the amount is a decimal string like "499.90"; a bare decimal string
+// is accepted as an amount in DefaultCurrency.
+type Money struct {
+	Amount   int64  `json:"amount" swaggertype:"string" example:"499.90"`
+	Currency string `json:"currency" example:"RUB"`
+}
+
+// minorUnitExceptions lists the currencies whose minor unit is not 1/100.
+var minorUnitExceptions = map[string]int{
+	"BHD": 3, "IQD": 3, "JOD": 3, "KWD": 3, "LYD": 3, "OMR": 3, "TND": 3,
+	"BIF": 0, "CLP": 0, "DJF": 0, "GNF": 0, "ISK": 0, "JPY": 0, "KMF": 0, "KRW": 0,
+	"PYG": 0, "RWF": 0, "UGX": 0, "VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// MinorUnits returns the number of decimal digits of the currency minor unit.
+func MinorUnits(currency string) int {
+	if digits, ok := minorUnitExceptions[currency]; ok {
+		return digits
+	}
+	return 2
+}
+
+func ParseMoney(amount, currency string) (Money, error) {
+	digits := MinorUnits(currency)
+
+	s := strings.TrimSpace(amount)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+
+	intPart, fracPart, hasPoint := strings.Cut(s, ".")
+	if intPart == "" || (hasPoint && fracPart == "") || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, amount)
+	}
+	if len(fracPart) > digits {
+		return Money{}, fmt.Errorf("%w: %q has more than %d decimal places for %s", ErrInvalidAmount, amount, digits, currency)
+	}
+
+	minor, err := strconv.ParseInt(intPart+fracPart+strings.Repeat("0", digits-len(fracPart)), 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrAmountOverflow, amount)
+	}
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Amount: minor, Currency: currency}, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (m Money) String() string {
+	digits := MinorUnits(m.Currency)
+
+	var magnitude big.Int
+	magnitude.Abs(big.NewInt(m.Amount))
+	s := magnitude.String()
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	if digits > 0 {
+		s = s[:len(s)-digits] + "." + s[len(s)-digits:]
+	}
+	if m.Amount < 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+// Add sums two amounts of the same currency and reports an overflow instead of
+// wrapping around.
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	if (other.Amount > 0 && m.Amount > math.MaxInt64-other.Amount) ||
+		(other.Amount < 0 && m.Amount < math.MinInt64-other.Amount) {
+		return Money{}, fmt.Errorf("%w: %s + %s %s", ErrAmountOverflow, m, other, m.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Convert converts the amount to the target currency at the rate rounding half
+// away from zero to the target minor unit.
+func (m Money) Convert(target string, rate *big.Rat) (Money, error) {
+	converted := new(big.Rat).Mul(big.NewRat(m.Amount, 1), rate)
+	if shift := MinorUnits(target) - MinorUnits(m.Currency); shift != 0 {
+		scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+		if shift > 0 {
+			converted.Mul(converted, scale)
+		} else {
+			converted.Quo(converted, scale)
+		}
+	}
+
+	quo, rem := new(big.Int).QuoRem(converted.Num(), converted.Denom(), new(big.Int))
+	if new(big.Int).Mul(rem.Abs(rem), big.NewInt(2)).Cmp(converted.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(converted.Sign())))
+	}
+	if !quo.IsInt64() {
+		return Money{}, fmt.Errorf("%w: %s %s to %s", ErrAmountOverflow, m, m.Currency, target)
+	}
+
+	return Money{Amount: quo.Int64(), Currency: target}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{
+		Amount:   m.String(),
+		Currency: m.Currency,
+	})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount string
+	if err := json.Unmarshal(data, &amount); err == nil {
+		parsed, err := ParseMoney(amount, DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	var raw struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%w: expected a decimal string or an object with amount and currency", ErrInvalidAmount)
+	}
+
+	currency := DefaultCurrency
+	if raw.Currency != "" {
+		var err error
+		if currency, err = ParseCurrency(raw.Currency); err != nil {
+			return err
+		}
+	}
+
+	parsed, err := ParseMoney(raw.Amount, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
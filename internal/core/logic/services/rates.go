@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ExchangeRateRepository interface {
+	CreateExchangeRate(ctx context.Context, rate *ExchangeRate) error
+	GetExchangeRate(ctx context.Context, ID uuid.UUID) (*ExchangeRate, error)
+	GetExchangeRates(ctx context.Context, fromCurrency, toCurrency string) ([]*ExchangeRate, error)
+	UpdateExchangeRate(ctx context.Context, rate *ExchangeRate) error
+	DeleteExchangeRate(ctx context.Context, ID uuid.UUID) error
+	FindConversionRates(ctx context.Context, currencies []string, target string, until time.Time) ([]*ExchangeRate, error)
+}
+
+type ExchangeRateService struct {
+	repo ExchangeRateRepository
+}
+
+func NewExchangeRateService(repo ExchangeRateRepository) *ExchangeRateService {
+	return &ExchangeRateService{
+		repo: repo,
+	}
+}
+
+func (s *ExchangeRateService) CreateExchangeRate(ctx context.Context, rate *ExchangeRate) error {
+	if err := normalizeExchangeRate(rate); err != nil {
+		return err
+	}
+	return s.repo.CreateExchangeRate(ctx, rate)
+}
+
+func (s *ExchangeRateService) GetExchangeRate(ctx context.Context, ID uuid.UUID) (*ExchangeRate, error) {
+	return s.repo.GetExchangeRate(ctx, ID)
+}
+
+func (s *ExchangeRateService) GetExchangeRates(ctx context.Context, fromCurrency, toCurrency string) ([]*ExchangeRate, error) {
+	return s.repo.GetExchangeRates(ctx, fromCurrency, toCurrency)
+}
+
+func (s *ExchangeRateService) UpdateExchangeRate(ctx context.Context, rate *ExchangeRate) error {
+	if err := normalizeExchangeRate(rate); err != nil {
+		return err
+	}
+	return s.repo.UpdateExchangeRate(ctx, rate)
+}
+
+func (s *ExchangeRateService) DeleteExchangeRate(ctx context.Context, ID uuid.UUID) error {
+	return s.repo.DeleteExchangeRate(ctx, ID)
+}
+
+func normalizeExchangeRate(rate *ExchangeRate) error {
+	var err error
+	if rate.FromCurrency, err = ParseCurrency(rate.FromCurrency); err != nil {
+		return err
+	}
+	if rate.ToCurrency, err = ParseCurrency(rate.ToCurrency); err != nil {
+		return err
+	}
+	if rate.FromCurrency == rate.ToCurrency {
+		return fmt.Errorf("%w: %s to itself", ErrInvalidRate, rate.FromCurrency)
+	}
+	if _, err := parseRate(rate.Rate); err != nil {
+		return err
+	}
+	rate.Month = monthStart(rate.Month)
+	return nil
+}
+
+func parseRate(s string) (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRate, s)
+	}
+	return rate, nil
+}
+
+type currencyPair struct {
+	from, to string
+}
+
+// rateTable holds the rates of every pair ordered by month.
+type rateTable map[currencyPair][]*ExchangeRate
+
+func newRateTable(rates []*ExchangeRate) rateTable {
+	table := make(rateTable)
+	for _, rate := range rates {
+		pair := currencyPair{from: rate.FromCurrency, to: rate.ToCurrency}
+		table[pair] = append(table[pair], rate)
+	}
+	for _, pairRates := range table {
+		sort.Slice(pairRates, func(i, j int) bool {
+			return pairRates[i].Month.Before(pairRates[j].Month)
+		})
+	}
+	return table
+}
+
+func (t rateTable) latest(pair currencyPair, month time.Time) *ExchangeRate {
+	var found *ExchangeRate
+	for _, rate := range t[pair] {
+		if rate.Month.After(month) {
+			break
+		}
+		found = rate
+	}
+	return found
+}
+
+// rateAt returns the rate in effect in the given month. A rate entered for the
+// opposite direction is inverted; the most recent of the two wins.
+func (t rateTable) rateAt(from, to string, month time.Time) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	direct := t.latest(currencyPair{from: from, to: to}, month)
+	inverse := t.latest(currencyPair{from: to, to: from}, month)
+	switch {
+	case direct != nil && (inverse == nil || !inverse.Month.After(direct.Month)):
+		return parseRate(direct.Rate)
+	case inverse != nil:
+		rate, err := parseRate(inverse.Rate)
+		if err != nil {
+			return nil, err
+		}
+		return rate.Inv(rate), nil
+	default:
+		return nil, fmt.Errorf("%w: %s to %s in %s", ErrRateNotFound, from, to, month.Format("01-2006"))
+	}
+}
@@ -2,6 +2,9 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,63 +13,456 @@ import (
 type SubscriptionRepository interface {
 	CreateService(ctx context.Context, srv *Service) error
 	GetService(ctx context.Context, ID uuid.UUID) (*Service, error)
-	GetServices(ctx context.Context, page, size int) ([]*Service, int, error)
-	UpdateService(ctx context.Context, srv *Service) error
-	DeleteService(ctx context.Context, ID uuid.UUID) error
+	GetServices(ctx context.Context, query *ListQuery) (*ServicePage, error)
+	StreamServices(ctx context.Context, query *ListQuery, fn func(srv *Service) error) error
+	// UpdateService also applies priceChange, if any, as a single change of
+	// the service.
+	UpdateService(ctx context.Context, srv *Service, priceChange *PriceChange) error
+	DeleteService(ctx context.Context, ID uuid.UUID, version int64) error
+	RestoreService(ctx context.Context, ID uuid.UUID) (*Service, error)
+	PurgeDeletedServices(ctx context.Context, deletedBefore time.Time) (int64, error)
 	FilterServices(ctx context.Context, filters *Filters) ([]*Service, error)
+	AggregateCosts(ctx context.Context, filters *Filters) ([]*CostEntry, error)
+	SetServicePrice(ctx context.Context, change *PriceChange) error
+	GetServicePrices(ctx context.Context, ID uuid.UUID) ([]*PriceChange, error)
+	GetServiceHistory(ctx context.Context, ID uuid.UUID) ([]*ServiceAuditEntry, error)
+	GroupActiveServices(ctx context.Context, month time.Time) ([]*ServiceGroup, error)
+	// Transaction runs fn so that the repository calls it makes with the
+	// passed context commit or roll back together.
+	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 type SubscriptionService struct {
-	repo SubscriptionRepository
+	repo  SubscriptionRepository
+	rates ExchangeRateRepository
 }
 
-func NewSubscriptionService(repo SubscriptionRepository) *SubscriptionService {
+func NewSubscriptionService(repo SubscriptionRepository, rates ExchangeRateRepository) *SubscriptionService {
 	return &SubscriptionService{
-		repo: repo,
+		repo:  repo,
+		rates: rates,
 	}
 }
 
 func (s *SubscriptionService) CreateService(ctx context.Context, srv *Service) error {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.CreateService")
+	defer span.End()
+
+	if err := prepareNewService(ctx, srv); err != nil {
+		return err
+	}
+
 	return s.repo.CreateService(ctx, srv)
 }
 
+// prepareNewService checks that the caller may create srv, fills in the
+// defaults and validates the result.
+func prepareNewService(ctx context.Context, srv *Service) error {
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		if srv.UserID != uuid.Nil && srv.UserID != *owner {
+			return fmt.Errorf("%w: cannot create a service for another user", ErrForbidden)
+		}
+		srv.UserID = *owner
+	}
+
+	if srv.BillingPeriod == "" {
+		srv.BillingPeriod = BillingPeriodMonth
+	}
+	if srv.BillingInterval == 0 {
+		srv.BillingInterval = 1
+	}
+	if srv.Price.Currency == "" {
+		srv.Price.Currency = DefaultCurrency
+	}
+
+	return srv.Validate()
+}
+
+// GetService hides the services of other users behind ErrNotFound so that
+// their IDs cannot be probed.
 func (s *SubscriptionService) GetService(ctx context.Context, ID uuid.UUID) (*Service, error) {
-	return s.repo.GetService(ctx, ID)
+	ctx, span := tracer.Start(ctx, "SubscriptionService.GetService")
+	defer span.End()
+
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	srv, err := s.repo.GetService(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil && srv.UserID != *owner {
+		return nil, ErrNotFound
+	}
+
+	return srv, nil
 }
 
-func (s *SubscriptionService) GetServices(ctx context.Context, page int, size int) ([]*Service, int, error) {
-	return s.repo.GetServices(ctx, page, size)
+// GetServices lists only the caller's own services to everybody but admins,
+// whatever user the query asks for.
+func (s *SubscriptionService) GetServices(ctx context.Context, query *ListQuery) (*ServicePage, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.GetServices")
+	defer span.End()
+
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		query.UserID = owner
+	}
+	if query.PriceCurrency == "" {
+		query.PriceCurrency = DefaultCurrency
+	}
+	if query.Cursor != nil {
+		if err := query.Cursor.validFor(query); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.repo.GetServices(ctx, query)
 }
 
+// ExportServices passes every service the query selects to fn, with the
+// access rules of GetServices; the query is not paginated.
+func (s *SubscriptionService) ExportServices(ctx context.Context, query *ListQuery, fn func(srv *Service) error) error {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.ExportServices")
+	defer span.End()
+
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return err
+	}
+	if owner != nil {
+		query.UserID = owner
+	}
+	if query.PriceCurrency == "" {
+		query.PriceCurrency = DefaultCurrency
+	}
+
+	return s.repo.StreamServices(ctx, query, fn)
+}
+
+// UpdateService replaces the service with srv; a zero user, billing period or
+// interval keeps the owner and falls back to the defaults of a new service.
+// It never rewrites the price of past months: a new price takes effect from
+// the current month on. A non-zero srv.Version makes the update fail with
+// ErrVersionMismatch unless the service is still at that version.
 func (s *SubscriptionService) UpdateService(ctx context.Context, srv *Service) error {
-	return s.repo.UpdateService(ctx, srv)
+	ctx, span := tracer.Start(ctx, "SubscriptionService.UpdateService")
+	defer span.End()
+
+	current, err := s.GetService(ctx, srv.ID)
+	if err != nil {
+		return err
+	}
+	if srv.UserID == uuid.Nil {
+		srv.UserID = current.UserID
+	}
+	if owner, _ := ownerScope(ctx); owner != nil && srv.UserID != current.UserID {
+		return fmt.Errorf("%w: cannot hand a service over to another user", ErrForbidden)
+	}
+	if srv.BillingPeriod == "" {
+		srv.BillingPeriod = BillingPeriodMonth
+	}
+	if srv.BillingInterval == 0 {
+		srv.BillingInterval = 1
+	}
+	if srv.Price.Currency == "" {
+		srv.Price.Currency = DefaultCurrency
+	}
+	if err := srv.Validate(); err != nil {
+		return err
+	}
+
+	var change *PriceChange
+	if srv.Price != current.Price {
+		change = &PriceChange{
+			ServiceID:     srv.ID,
+			EffectiveFrom: monthStart(time.Now()),
+			Price:         srv.Price,
+		}
+	}
+	if change != nil && change.Price.Currency != current.Price.Currency {
+		prices, err := s.repo.GetServicePrices(ctx, srv.ID)
+		if err != nil {
+			return err
+		}
+		if err := change.validateCurrency(srv, prices); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.UpdateService(ctx, srv, change); err != nil {
+		return err
+	}
+
+	updated, err := s.repo.GetService(ctx, srv.ID)
+	if err != nil {
+		return err
+	}
+	*srv = *updated
+
+	return nil
 }
 
-func (s *SubscriptionService) DeleteService(ctx context.Context, ID uuid.UUID) error {
-	return s.repo.DeleteService(ctx, ID)
+// maxPatchAttempts bounds how often PatchService reapplies a patch to a
+// service that keeps changing under it.
+const maxPatchAttempts = 3
+
+// PatchService applies patch to the current service and stores the result.
+// With a non-zero version the service must be at that version; without one
+// the patch is reapplied when the service changed in the meantime.
+func (s *SubscriptionService) PatchService(ctx context.Context, ID uuid.UUID, version int64, patch func(srv *Service) error) (*Service, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.PatchService")
+	defer span.End()
+
+	for attempt := 1; ; attempt++ {
+		srv, err := s.GetService(ctx, ID)
+		if err != nil {
+			return nil, err
+		}
+		if version != 0 && srv.Version != version {
+			return nil, ErrVersionMismatch
+		}
+
+		if err := patch(srv); err != nil {
+			return nil, err
+		}
+
+		err = s.UpdateService(ctx, srv)
+		if errors.Is(err, ErrVersionMismatch) && version == 0 && attempt < maxPatchAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return srv, nil
+	}
 }
 
-func (s *SubscriptionService) CumulateServices(ctx context.Context, filters *Filters) (int, error) {
-	filteredServices, err := s.repo.FilterServices(ctx, filters)
+// DeleteService deletes the service only at the given version unless it is
+// zero.
+func (s *SubscriptionService) DeleteService(ctx context.Context, ID uuid.UUID, version int64) error {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.DeleteService")
+	defer span.End()
+
+	if _, err := s.GetService(ctx, ID); err != nil {
+		return err
+	}
+
+	return s.repo.DeleteService(ctx, ID, version)
+}
+
+func (s *SubscriptionService) RestoreService(ctx context.Context, ID uuid.UUID) (*Service, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.RestoreService")
+	defer span.End()
+
+	// Deleted services are out of reach of GetService; their history tells
+	// whose they are.
+	if _, err := s.GetServiceHistory(ctx, ID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.RestoreService(ctx, ID)
+}
+
+// PurgeDeletedServices permanently removes the services deleted longer than
+// olderThan ago and returns how many there were. Only admins may purge.
+func (s *SubscriptionService) PurgeDeletedServices(ctx context.Context, olderThan time.Duration) (int64, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.PurgeDeletedServices")
+	defer span.End()
+
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+	if !p.IsAdmin() {
+		return 0, fmt.Errorf("%w: only admins can purge services", ErrForbidden)
+	}
+
+	return s.repo.PurgeDeletedServices(ctx, time.Now().Add(-olderThan))
+}
+
+func (s *SubscriptionService) SchedulePriceChange(ctx context.Context, change *PriceChange) error {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.SchedulePriceChange")
+	defer span.End()
+
+	srv, err := s.GetService(ctx, change.ServiceID)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if err := change.Validate(srv); err != nil {
+		return err
 	}
 
-	sum := 0
-	var cumPrice int
-	var start, end time.Time
-	for _, srv := range filteredServices {
-		start = maxDate(filters.StartDate, srv.StartDate)
-		if srv.EndDate != nil {
-			end = minDate(filters.EndDate, *srv.EndDate)
-		} else {
-			end = filters.EndDate
-		}
-		cumPrice = monthsBetween(start, end) * srv.Price
-		if cumPrice > 0 {
-			sum += cumPrice
+	change.EffectiveFrom = monthStart(change.EffectiveFrom)
+	return s.repo.SetServicePrice(ctx, change)
+}
+
+func (s *SubscriptionService) GetServicePrices(ctx context.Context, ID uuid.UUID) ([]*PriceChange, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.GetServicePrices")
+	defer span.End()
+
+	if _, err := s.GetService(ctx, ID); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetServicePrices(ctx, ID)
+}
+
+// GetServiceHistory also covers deleted services. Access follows the user the
+// service belonged to most recently.
+func (s *SubscriptionService) GetServiceHistory(ctx context.Context, ID uuid.UUID) ([]*ServiceAuditEntry, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.GetServiceHistory")
+	defer span.End()
+
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := s.repo.GetServiceHistory(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 || (owner != nil && history[len(history)-1].UserID != *owner) {
+		return nil, ErrNotFound
+	}
+
+	return history, nil
+}
+
+func (s *SubscriptionService) CumulateServices(ctx context.Context, filters *Filters) (Money, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.CumulateServices")
+	defer span.End()
+
+	costEntries, err := s.aggregateConvertedCosts(ctx, filters)
+	if err != nil {
+		return Money{}, err
+	}
+
+	sum := Money{Currency: filters.TargetCurrency}
+	for _, entry := range costEntries {
+		if sum, err = sum.Add(entry.Amount); err != nil {
+			return Money{}, err
 		}
 	}
 
 	return sum, nil
 }
+
+func (s *SubscriptionService) CumulateServicesBreakdown(ctx context.Context, filters *Filters) ([]*MonthlyCost, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.CumulateServicesBreakdown")
+	defer span.End()
+
+	costEntries, err := s.aggregateConvertedCosts(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	return breakdownCosts(costEntries, filters)
+}
+
+func (s *SubscriptionService) aggregateConvertedCosts(ctx context.Context, filters *Filters) ([]*CostEntry, error) {
+	owner, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if owner != nil {
+		filters.UserIDs = []*uuid.UUID{owner}
+	}
+
+	if filters.TargetCurrency == "" {
+		filters.TargetCurrency = DefaultCurrency
+	}
+	if err := filters.Validate(); err != nil {
+		return nil, err
+	}
+
+	costEntries, err := s.repo.AggregateCosts(ctx, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.convertCosts(ctx, costEntries, filters.TargetCurrency); err != nil {
+		return nil, err
+	}
+
+	return costEntries, nil
+}
+
+// convertCosts converts every entry to the target currency at the rate in
+// effect in the entry month.
+func (s *SubscriptionService) convertCosts(ctx context.Context, costEntries []*CostEntry, target string) error {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.convertCosts")
+	defer span.End()
+
+	var currencies []string
+	var until time.Time
+	seen := make(map[string]bool)
+	for _, entry := range costEntries {
+		if currency := entry.Amount.Currency; currency != target && !seen[currency] {
+			seen[currency] = true
+			currencies = append(currencies, currency)
+		}
+		until = maxDate(until, entry.Month)
+	}
+	if len(currencies) == 0 {
+		return nil
+	}
+
+	rates, err := s.rates.FindConversionRates(ctx, currencies, target, until)
+	if err != nil {
+		return err
+	}
+
+	table := newRateTable(rates)
+	for _, entry := range costEntries {
+		rate, err := table.rateAt(entry.Amount.Currency, target, entry.Month)
+		if err != nil {
+			return err
+		}
+		if entry.Amount, err = entry.Amount.Convert(target, rate); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// periodsPerMonth is how many billing periods of each kind fit in a month on
+// average.
+var periodsPerMonth = map[BillingPeriod]float64{
+	BillingPeriodWeek:    52.0 / 12,
+	BillingPeriodMonth:   1,
+	BillingPeriodQuarter: 1.0 / 3,
+	BillingPeriodYear:    1.0 / 12,
+}
+
+// GetSubscriptionStats is not scoped to a caller: it feeds the service
+// metrics and must not be exposed through the API.
+func (s *SubscriptionService) GetSubscriptionStats(ctx context.Context) (*SubscriptionStats, error) {
+	ctx, span := tracer.Start(ctx, "SubscriptionService.GetSubscriptionStats")
+	defer span.End()
+
+	groups, err := s.repo.GroupActiveServices(ctx, monthStart(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &SubscriptionStats{MonthlyRunRate: make(map[string]float64)}
+	for _, group := range groups {
+		stats.ActiveServices += group.Services
+		amount := float64(group.Amount.Amount) / math.Pow10(MinorUnits(group.Amount.Currency))
+		stats.MonthlyRunRate[group.Amount.Currency] += amount * periodsPerMonth[group.BillingPeriod] / float64(max(group.BillingInterval, 1))
+	}
+
+	return stats, nil
+}
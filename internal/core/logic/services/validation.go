@@ -0,0 +1,121 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// FieldError is a rule the value of a field breaks; Field is named as in the
+// JSON of the API.
+type FieldError struct {
+	Field   string `json:"field" example:"end_date"`
+	Message string `json:"message" example:"must not be before start_date"`
+}
+
+// ValidationError lists every field that breaks a rule. It wraps
+// ErrValidation.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Field + " " + f.Message
+	}
+	return ErrValidation.Error() + ": " + strings.Join(problems, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+// validator collects the rules a value breaks.
+type validator struct {
+	fields []*FieldError
+}
+
+func (v *validator) check(ok bool, field, message string) {
+	if !ok {
+		v.fields = append(v.fields, &FieldError{Field: field, Message: message})
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+func validCurrency(code string) bool {
+	parsed, err := ParseCurrency(code)
+	return err == nil && parsed == code
+}
+
+// Validate checks the service as it is about to be stored, with the defaults
+// filled in.
+func (srv *Service) Validate() error {
+	var v validator
+
+	v.check(strings.TrimSpace(srv.ServiceName) != "", "service_name", "must not be empty")
+	v.check(srv.Price.Amount >= 0, "price", "must not be negative")
+	v.check(validCurrency(srv.Price.Currency), "price.currency", "must be an ISO 4217 code")
+	v.check(srv.UserID != uuid.Nil, "user_id", "is required")
+	v.check(!srv.StartDate.IsZero(), "start_date", "is required")
+	if srv.EndDate != nil {
+		v.check(!srv.EndDate.Before(srv.StartDate), "end_date", "must not be before start_date")
+	}
+	_, err := ParseBillingPeriod(string(srv.BillingPeriod))
+	v.check(err == nil, "billing_period", "must be week, month, quarter or year")
+	v.check(srv.BillingInterval > 0, "billing_interval", "must be positive")
+
+	return v.err()
+}
+
+// Validate checks the change against the service it is scheduled for: the
+// prices of a service share its currency and none takes effect before it
+// starts, where the history would never reach it.
+func (pc *PriceChange) Validate(srv *Service) error {
+	var v validator
+
+	v.check(!monthStart(pc.EffectiveFrom).Before(monthStart(srv.StartDate)), "effective_from", "must not be before the month of start_date")
+
+	v.check(pc.Price.Amount >= 0, "price", "must not be negative")
+	v.check(validCurrency(pc.Price.Currency), "price.currency", "must be an ISO 4217 code")
+	v.check(!validCurrency(pc.Price.Currency) || pc.Price.Currency == srv.Price.Currency,
+		"price.currency", "must be "+srv.Price.Currency+", the currency of the service")
+
+	return v.err()
+}
+
+// validateCurrency checks the change an update makes to the price of srv
+// against the prices it had: as the prices of a service share its currency,
+// a new currency has to replace the only price of the service.
+func (pc *PriceChange) validateCurrency(srv *Service, prices []*PriceChange) error {
+	var v validator
+
+	if len(prices) > 0 {
+		currency := prices[0].Price.Currency
+		replacesAll := len(prices) == 1 && !pc.EffectiveFrom.After(srv.StartDate)
+		v.check(pc.Price.Currency == currency || replacesAll,
+			"price.currency", "must be "+currency+" while the service has prices in it")
+	}
+
+	return v.err()
+}
+
+// Validate checks the window and the currency of the filters.
+func (f *Filters) Validate() error {
+	var v validator
+
+	v.check(!f.StartDate.IsZero(), "start_date", "is required")
+	v.check(!f.EndDate.IsZero(), "end_date", "is required")
+	v.check(!f.EndDate.Before(f.StartDate), "end_date", "must not be before start_date")
+	if f.TargetCurrency != "" {
+		v.check(validCurrency(f.TargetCurrency), "target_currency", "must be an ISO 4217 code")
+	}
+
+	return v.err()
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPriceChangeValidate(t *testing.T) {
+	srv := &Service{Price: Money{Amount: 1000, Currency: "USD"}, StartDate: time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)}
+	march := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		price         Money
+		effectiveFrom time.Time
+		fields        []string
+	}{
+		{"same currency", Money{Amount: 1200, Currency: "USD"}, march.AddDate(0, 2, 0), nil},
+		{"free", Money{Amount: 0, Currency: "USD"}, march.AddDate(0, 2, 0), nil},
+		{"start month", Money{Amount: 1200, Currency: "USD"}, march, nil},
+		{"before start", Money{Amount: 1200, Currency: "USD"}, march.AddDate(0, -1, 0), []string{"effective_from"}},
+		{"negative", Money{Amount: -1, Currency: "USD"}, march, []string{"price"}},
+		{"other currency", Money{Amount: 1200, Currency: "EUR"}, march, []string{"price.currency"}},
+		{"unknown currency", Money{Amount: -5, Currency: "usd"}, march, []string{"price", "price.currency"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&PriceChange{Price: tt.price, EffectiveFrom: tt.effectiveFrom}).Validate(srv)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("got %v, want valid", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || !errors.Is(err, ErrValidation) {
+				t.Fatalf("got %v, want a validation error", err)
+			}
+			var fields []string
+			for _, f := range validationErr.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("fields: got %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestPriceChangeValidateCurrency(t *testing.T) {
+	march := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	srv := &Service{Price: Money{Amount: 1000, Currency: "EUR"}, StartDate: march}
+	base := &PriceChange{EffectiveFrom: march, Price: Money{Amount: 1000, Currency: "USD"}}
+	scheduled := &PriceChange{EffectiveFrom: march.AddDate(0, 3, 0), Price: Money{Amount: 1200, Currency: "USD"}}
+
+	tests := []struct {
+		name          string
+		price         Money
+		effectiveFrom time.Time
+		prices        []*PriceChange
+		wantErr       bool
+	}{
+		{"same currency after scheduled prices", Money{Amount: 1100, Currency: "USD"}, march.AddDate(0, 1, 0), []*PriceChange{base, scheduled}, false},
+		{"replaces the only price", Money{Amount: 900, Currency: "EUR"}, march, []*PriceChange{base}, false},
+		{"scheduled prices", Money{Amount: 900, Currency: "EUR"}, march, []*PriceChange{base, scheduled}, true},
+		{"after the start", Money{Amount: 900, Currency: "EUR"}, march.AddDate(0, 1, 0), []*PriceChange{base}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&PriceChange{Price: tt.price, EffectiveFrom: tt.effectiveFrom}).validateCurrency(srv, tt.prices)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("got %v, want valid", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || len(validationErr.Fields) != 1 || validationErr.Fields[0].Field != "price.currency" {
+				t.Errorf("got %v, want price.currency rejected", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// statsTimeout bounds the queries a scrape makes.
+const statsTimeout = 5 * time.Second
+
+type StatsSource interface {
+	GetSubscriptionStats(ctx context.Context) (*services.SubscriptionStats, error)
+}
+
+// subscriptionCollector reads the stats of the subscriptions on every scrape.
+type subscriptionCollector struct {
+	source  StatsSource
+	active  *prometheus.Desc
+	runRate *prometheus.Desc
+}
+
+func NewSubscriptionCollector(source StatsSource) prometheus.Collector {
+	return &subscriptionCollector{
+		source: source,
+		active: prometheus.NewDesc("subscriptions_active",
+			"Services active in the current month.", nil, nil),
+		runRate: prometheus.NewDesc("subscriptions_monthly_run_rate",
+			"What the active services cost a month, in major units of the currency.", []string{"currency"}, nil),
+	}
+}
+
+func (sc *subscriptionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sc.active
+	ch <- sc.runRate
+}
+
+// Collect leaves the gauges out when the stats cannot be read, so that the
+// rest of the scrape still succeeds.
+func (sc *subscriptionCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statsTimeout)
+	defer cancel()
+
+	stats, err := sc.source.GetSubscriptionStats(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"details": err.Error(),
+		}).Warn("Failed to collect subscription stats")
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(sc.active, prometheus.GaugeValue, float64(stats.ActiveServices))
+	for currency, amount := range stats.MonthlyRunRate {
+		ch <- prometheus.MustNewConstMetric(sc.runRate, prometheus.GaugeValue, amount, currency)
+	}
+}
@@ -1,43 +1,343 @@
 package entities
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"gorm.io/gorm"
 )
 
 type ServiceEntity struct {
 	ID          uuid.UUID `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	ServiceName string    `gorm:"not null"`
-	Price       int       `gorm:"not null"`
+	Price       int64     `gorm:"not null"`
+	Currency    string    `gorm:"not null;type:char(3);default:RUB"`
 	UserID      uuid.UUID `gorm:"not null;type:uuid"`
 	StartDate   time.Time `gorm:"not null"`
 	EndDate     *time.Time
+
+	BillingPeriod   string `gorm:"not null;default:month"`
+	BillingInterval int    `gorm:"not null;default:1"`
+
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Version   int64          `gorm:"not null;default:1"`
+
+	CurrentPrice    *int64               `gorm:"->"`
+	CurrentCurrency *string              `gorm:"->"`
+	Prices          []ServicePriceEntity `gorm:"foreignKey:ServiceID"`
 }
 
 func (ServiceEntity) TableName() string {
 	return "services"
 }
 
+type ServicePriceEntity struct {
+	ServiceID     uuid.UUID `gorm:"primaryKey;type:uuid"`
+	EffectiveFrom time.Time `gorm:"primaryKey;type:date"`
+	Price         int64     `gorm:"not null"`
+	Currency      string    `gorm:"not null;type:char(3)"`
+}
+
+func (ServicePriceEntity) TableName() string {
+	return "service_prices"
+}
+
+func NewServicePriceEntityFromLogic(pc *services.PriceChange) *ServicePriceEntity {
+	return &ServicePriceEntity{
+		ServiceID:     pc.ServiceID,
+		EffectiveFrom: pc.EffectiveFrom,
+		Price:         pc.Price.Amount,
+		Currency:      pc.Price.Currency,
+	}
+}
+
+func (spe *ServicePriceEntity) ToLogicPriceChange() *services.PriceChange {
+	return &services.PriceChange{
+		ServiceID:     spe.ServiceID,
+		EffectiveFrom: spe.EffectiveFrom,
+		Price:         services.Money{Amount: spe.Price, Currency: spe.Currency},
+	}
+}
+
 func NewServiceEntityFromLogic(s *services.Service) *ServiceEntity {
 	return &ServiceEntity{
 		ID:          s.ID,
 		ServiceName: s.ServiceName,
-		Price:       s.Price,
+		Price:       s.Price.Amount,
+		Currency:    s.Price.Currency,
 		UserID:      s.UserID,
 		StartDate:   s.StartDate,
 		EndDate:     s.EndDate,
+
+		BillingPeriod:   string(s.BillingPeriod),
+		BillingInterval: s.BillingInterval,
+
+		Version: s.Version,
 	}
 }
 
 func (se *ServiceEntity) ToLogicService() *services.Service {
-	return &services.Service{
+	srv := &services.Service{
 		ID:          se.ID,
 		ServiceName: se.ServiceName,
-		Price:       se.Price,
+		Price:       services.Money{Amount: se.Price, Currency: se.Currency},
 		UserID:      se.UserID,
 		StartDate:   se.StartDate,
 		EndDate:     se.EndDate,
+
+		BillingPeriod:   services.BillingPeriod(se.BillingPeriod),
+		BillingInterval: se.BillingInterval,
+
+		Version: se.Version,
+	}
+	if se.DeletedAt.Valid {
+		srv.DeletedAt = &se.DeletedAt.Time
 	}
+	if se.CurrentPrice != nil && se.CurrentCurrency != nil {
+		srv.Price = services.Money{Amount: *se.CurrentPrice, Currency: *se.CurrentCurrency}
+	}
+
+	srv.Prices = []*services.PriceChange{se.BasePriceChange()}
+	for _, priceEntity := range se.Prices {
+		if priceEntity.EffectiveFrom.After(se.StartDate) {
+			srv.Prices = append(srv.Prices, priceEntity.ToLogicPriceChange())
+		}
+	}
+
+	return srv
+}
+
+// BasePriceChange represents the price stored on the service row, which is in
+// effect from the start date until the first scheduled change.
+func (se *ServiceEntity) BasePriceChange() *services.PriceChange {
+	return &services.PriceChange{
+		ServiceID:     se.ID,
+		EffectiveFrom: se.StartDate,
+		Price:         services.Money{Amount: se.Price, Currency: se.Currency},
+	}
+}
+
+// CostEntryEntity and ServiceGroupEntity scan the sums as text: SUM over
+// bigint is numeric in Postgres and need not fit into int64.
+type CostEntryEntity struct {
+	Month       time.Time
+	ServiceName string
+	UserID      uuid.UUID
+	Currency    string
+	Amount      string
+}
+
+func (ce *CostEntryEntity) ToLogicCostEntry() (*services.CostEntry, error) {
+	amount, err := parseSum(ce.Amount, ce.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services.CostEntry{
+		Month:       ce.Month,
+		ServiceName: ce.ServiceName,
+		UserID:      ce.UserID,
+		Amount:      amount,
+	}, nil
+}
+
+type ServiceGroupEntity struct {
+	BillingPeriod   string
+	BillingInterval int
+	Currency        string
+	Services        int64
+	Amount          string
+}
+
+func (sg *ServiceGroupEntity) ToLogicServiceGroup() (*services.ServiceGroup, error) {
+	amount, err := parseSum(sg.Amount, sg.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return &services.ServiceGroup{
+		BillingPeriod:   services.BillingPeriod(sg.BillingPeriod),
+		BillingInterval: sg.BillingInterval,
+		Services:        sg.Services,
+		Amount:          amount,
+	}, nil
+}
+
+func parseSum(sum, currency string) (services.Money, error) {
+	amount, err := strconv.ParseInt(sum, 10, 64)
+	if err != nil {
+		return services.Money{}, fmt.Errorf("%w: %s minor units of %s", services.ErrAmountOverflow, sum, currency)
+	}
+	return services.Money{Amount: amount, Currency: currency}, nil
+}
+
+type ExchangeRateEntity struct {
+	ID           uuid.UUID `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	FromCurrency string    `gorm:"not null;type:char(3)"`
+	ToCurrency   string    `gorm:"not null;type:char(3)"`
+	Month        time.Time `gorm:"not null;type:date"`
+	Rate         string    `gorm:"not null;type:numeric"`
+}
+
+func (ExchangeRateEntity) TableName() string {
+	return "exchange_rates"
+}
+
+func NewExchangeRateEntityFromLogic(er *services.ExchangeRate) *ExchangeRateEntity {
+	return &ExchangeRateEntity{
+		ID:           er.ID,
+		FromCurrency: er.FromCurrency,
+		ToCurrency:   er.ToCurrency,
+		Month:        er.Month,
+		Rate:         er.Rate,
+	}
+}
+
+func (ere *ExchangeRateEntity) ToLogicExchangeRate() *services.ExchangeRate {
+	return &services.ExchangeRate{
+		ID:           ere.ID,
+		FromCurrency: ere.FromCurrency,
+		ToCurrency:   ere.ToCurrency,
+		Month:        ere.Month,
+		Rate:         ere.Rate,
+	}
+}
+
+type APIKeyEntity struct {
+	ID         uuid.UUID      `gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Name       string         `gorm:"not null"`
+	Prefix     string         `gorm:"not null"`
+	SecretHash string         `gorm:"not null;uniqueIndex"`
+	Scopes     pq.StringArray `gorm:"not null;type:text[]"`
+	CreatedAt  time.Time      `gorm:"not null"`
+	ExpiresAt  *time.Time
+	RevokedAt  *time.Time
+}
+
+func (APIKeyEntity) TableName() string {
+	return "api_keys"
+}
+
+func NewAPIKeyEntityFromLogic(k *services.APIKey, secretHash string) *APIKeyEntity {
+	scopes := make(pq.StringArray, 0, len(k.Scopes))
+	for _, scope := range k.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	return &APIKeyEntity{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		SecretHash: secretHash,
+		Scopes:     scopes,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  k.ExpiresAt,
+		RevokedAt:  k.RevokedAt,
+	}
+}
+
+func (ke *APIKeyEntity) ToLogicAPIKey() *services.APIKey {
+	scopes := make([]services.Scope, 0, len(ke.Scopes))
+	for _, scope := range ke.Scopes {
+		scopes = append(scopes, services.Scope(scope))
+	}
+
+	return &services.APIKey{
+		ID:        ke.ID,
+		Name:      ke.Name,
+		Prefix:    ke.Prefix,
+		Scopes:    scopes,
+		CreatedAt: ke.CreatedAt,
+		ExpiresAt: ke.ExpiresAt,
+		RevokedAt: ke.RevokedAt,
+	}
+}
+
+type ServiceAuditEntity struct {
+	ID        int64      `gorm:"primaryKey;autoIncrement"`
+	ServiceID uuid.UUID  `gorm:"not null;type:uuid"`
+	UserID    uuid.UUID  `gorm:"not null;type:uuid"`
+	ActorID   *uuid.UUID `gorm:"type:uuid"`
+	ActorRole *string
+	Action    string    `gorm:"not null"`
+	Before    *string   `gorm:"type:jsonb"`
+	After     *string   `gorm:"type:jsonb"`
+	CreatedAt time.Time `gorm:"not null"`
+}
+
+func (ServiceAuditEntity) TableName() string {
+	return "service_audit"
+}
+
+func (sae *ServiceAuditEntity) ToLogicServiceAuditEntry() *services.ServiceAuditEntry {
+	entry := &services.ServiceAuditEntry{
+		ID:        sae.ID,
+		ServiceID: sae.ServiceID,
+		UserID:    sae.UserID,
+		ActorID:   sae.ActorID,
+		Action:    services.AuditAction(sae.Action),
+		CreatedAt: sae.CreatedAt,
+	}
+	if sae.ActorRole != nil {
+		entry.ActorRole = services.Role(*sae.ActorRole)
+	}
+	if sae.Before != nil {
+		entry.Before = json.RawMessage(*sae.Before)
+	}
+	if sae.After != nil {
+		entry.After = json.RawMessage(*sae.After)
+	}
+
+	return entry
+}
+
+type IdempotencyKeyEntity struct {
+	Subject     uuid.UUID `gorm:"primaryKey;type:uuid"`
+	Key         string    `gorm:"primaryKey"`
+	RequestHash string    `gorm:"not null"`
+	Status      *int
+	Response    []byte
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+}
+
+func (IdempotencyKeyEntity) TableName() string {
+	return "idempotency_keys"
+}
+
+func NewIdempotencyKeyEntityFromLogic(rec *services.IdempotencyRecord) *IdempotencyKeyEntity {
+	entity := &IdempotencyKeyEntity{
+		Subject:     rec.Subject,
+		Key:         rec.Key,
+		RequestHash: rec.RequestHash,
+		Response:    rec.Response,
+		CreatedAt:   rec.CreatedAt,
+		ExpiresAt:   rec.ExpiresAt,
+	}
+	if rec.Status != 0 {
+		entity.Status = &rec.Status
+	}
+
+	return entity
+}
+
+func (ike *IdempotencyKeyEntity) ToLogicIdempotencyRecord() *services.IdempotencyRecord {
+	rec := &services.IdempotencyRecord{
+		Key:         ike.Key,
+		Subject:     ike.Subject,
+		RequestHash: ike.RequestHash,
+		Response:    ike.Response,
+		CreatedAt:   ike.CreatedAt,
+		ExpiresAt:   ike.ExpiresAt,
+	}
+	if ike.Status != nil {
+		rec.Status = *ike.Status
+	}
+
+	return rec
 }
@@ -0,0 +1,80 @@
+package repository_services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type GormAPIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) services.APIKeyRepository {
+	return &GormAPIKeyRepository{db: db}
+}
+
+func (r *GormAPIKeyRepository) CreateAPIKey(ctx context.Context, key *services.APIKey, secretHash string) error {
+	keyEntity := entities.NewAPIKeyEntityFromLogic(key, secretHash)
+
+	result := r.db.WithContext(ctx).Create(&keyEntity)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	*key = *keyEntity.ToLogicAPIKey()
+
+	return nil
+}
+
+func (r *GormAPIKeyRepository) GetAPIKeys(ctx context.Context) ([]*services.APIKey, error) {
+	var keyEntities []*entities.APIKeyEntity
+
+	result := r.db.WithContext(ctx).Order("created_at").Find(&keyEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	keys := make([]*services.APIKey, 0, len(keyEntities))
+	for _, entity := range keyEntities {
+		keys = append(keys, entity.ToLogicAPIKey())
+	}
+
+	return keys, nil
+}
+
+func (r *GormAPIKeyRepository) GetAPIKeyByHash(ctx context.Context, secretHash string) (*services.APIKey, error) {
+	var keyEntity *entities.APIKeyEntity
+	result := r.db.WithContext(ctx).
+		First(&keyEntity, "secret_hash = ?", secretHash)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, services.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return keyEntity.ToLogicAPIKey(), nil
+}
+
+// RevokeAPIKey keeps the row so that the key stays listed; revoking a key
+// twice keeps the first revocation time.
+func (r *GormAPIKeyRepository) RevokeAPIKey(ctx context.Context, ID uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Model(&entities.APIKeyEntity{}).
+		Where("id = ?", ID).
+		Update("revoked_at", gorm.Expr("COALESCE(revoked_at, ?)", time.Now()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return services.ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package repository_services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// serviceState is the row of a service with its scheduled prices as a JSON
+// snapshot for the audit log.
+type serviceState struct {
+	UserID   uuid.UUID
+	Deleted  bool
+	Snapshot string
+}
+
+// snapshotSQL is the JSON snapshot of a services row named row: its columns
+// and its scheduled prices.
+func snapshotSQL(row string) string {
+	return fmt.Sprintf(`to_jsonb(%[1]s) || jsonb_build_object('prices', COALESCE((
+			SELECT jsonb_agg(to_jsonb(service_prices) - 'service_id' ORDER BY service_prices.effective_from)
+			FROM service_prices
+			WHERE service_prices.service_id = %[1]s.id
+		), '[]'::jsonb))`, row)
+}
+
+// serviceSnapshot locks the service row, deleted or not, and returns its
+// state, or nil when the service does not exist.
+func serviceSnapshot(tx *gorm.DB, ID uuid.UUID) (*serviceState, error) {
+	var states []serviceState
+	result := tx.Raw(`SELECT services.user_id, services.deleted_at IS NOT NULL AS deleted,
+			`+snapshotSQL("services")+` AS snapshot
+		FROM services
+		WHERE services.id = ?
+		FOR UPDATE OF services`, ID).
+		Scan(&states)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(states) == 0 {
+		return nil, nil
+	}
+
+	return &states[0], nil
+}
+
+// appendAudit records the current state of the service as the result of the
+// action; it must run in the transaction of the mutation.
+func (r *GormServiceRepository) appendAudit(ctx context.Context, ID uuid.UUID, action services.AuditAction, before *serviceState) error {
+	tx := r.conn(ctx)
+
+	after, err := serviceSnapshot(tx, ID)
+	if err != nil {
+		return err
+	}
+
+	entry := &entities.ServiceAuditEntity{
+		ServiceID: ID,
+		Action:    string(action),
+	}
+	if before != nil {
+		entry.UserID = before.UserID
+		entry.Before = &before.Snapshot
+	}
+	if after != nil {
+		entry.UserID = after.UserID
+		entry.After = &after.Snapshot
+	}
+	entry.ActorID, entry.ActorRole = actor(ctx)
+
+	return tx.Create(entry).Error
+}
+
+func actor(ctx context.Context) (*uuid.UUID, *string) {
+	principal, ok := services.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	role := string(principal.Role)
+	return &principal.Subject, &role
+}
+
+// auditedMutation applies mutate to an existing service and appends the audit
+// entry with the state before and after it in one transaction. Only restore
+// applies to deleted services, every other action only to live ones.
+func (r *GormServiceRepository) auditedMutation(ctx context.Context, ID uuid.UUID, action services.AuditAction, mutate func(tx *gorm.DB) error) error {
+	return r.Transaction(ctx, func(ctx context.Context) error {
+		before, err := serviceSnapshot(r.conn(ctx), ID)
+		if err != nil {
+			return err
+		}
+		if before == nil || before.Deleted != (action == services.AuditActionRestore) {
+			return services.ErrNotFound
+		}
+
+		if err := mutate(r.conn(ctx)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return services.ErrNotFound
+			}
+			return err
+		}
+
+		return r.appendAudit(ctx, ID, action, before)
+	})
+}
+
+// PurgeDeletedServices permanently removes the services deleted before the
+// given time. The audit log keeps their last state; the statement sees the
+// prices as they were before the cascade removes them.
+func (r *GormServiceRepository) PurgeDeletedServices(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.PurgeDeletedServices")
+	defer span.End()
+
+	actorID, actorRole := actor(ctx)
+
+	result := r.conn(ctx).Exec(`WITH purged AS (
+			DELETE FROM services
+			WHERE deleted_at < @deleted_before
+			RETURNING *
+		)
+		INSERT INTO service_audit (service_id, user_id, actor_id, actor_role, action, before)
+		SELECT purged.id, purged.user_id, @actor_id, @actor_role, @action, `+snapshotSQL("purged")+`
+		FROM purged`,
+		sql.Named("deleted_before", deletedBefore),
+		sql.Named("actor_id", actorID),
+		sql.Named("actor_role", actorRole),
+		sql.Named("action", string(services.AuditActionPurge)),
+	)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return result.RowsAffected, nil
+}
+
+func (r *GormServiceRepository) GetServiceHistory(ctx context.Context, ID uuid.UUID) ([]*services.ServiceAuditEntry, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.GetServiceHistory")
+	defer span.End()
+
+	var auditEntities []*entities.ServiceAuditEntity
+
+	result := r.conn(ctx).
+		Where("service_id = ?", ID).
+		Order("created_at, id").
+		Find(&auditEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	history := make([]*services.ServiceAuditEntry, 0, len(auditEntities))
+	for _, entity := range auditEntities {
+		history = append(history, entity.ToLogicServiceAuditEntry())
+	}
+
+	return history, nil
+}
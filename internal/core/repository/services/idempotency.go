@@ -0,0 +1,78 @@
+package repository_services
+
+import (
+	"context"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type GormIdempotencyRepository struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) services.IdempotencyRepository {
+	return &GormIdempotencyRepository{db: db}
+}
+
+// ClaimIdempotencyKey takes over an expired record of the key in place, so a
+// key is reusable once it expired even before it is purged.
+func (r *GormIdempotencyRepository) ClaimIdempotencyKey(ctx context.Context, rec *services.IdempotencyRecord) (*services.IdempotencyRecord, error) {
+	keyEntity := entities.NewIdempotencyKeyEntityFromLogic(rec)
+
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "subject"}, {Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"request_hash": keyEntity.RequestHash,
+				"status":       nil,
+				"response":     nil,
+				"created_at":   keyEntity.CreatedAt,
+				"expires_at":   keyEntity.ExpiresAt,
+			}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Expr{SQL: "idempotency_keys.expires_at <= ?", Vars: []any{keyEntity.CreatedAt}},
+			}},
+		}).
+		Create(keyEntity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil, nil
+	}
+
+	var existing entities.IdempotencyKeyEntity
+	err := r.db.WithContext(ctx).
+		First(&existing, "subject = ? AND key = ?", rec.Subject, rec.Key).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return existing.ToLogicIdempotencyRecord(), nil
+}
+
+func (r *GormIdempotencyRepository) CompleteIdempotencyKey(ctx context.Context, rec *services.IdempotencyRecord) error {
+	return r.db.WithContext(ctx).
+		Model(&entities.IdempotencyKeyEntity{}).
+		Where("subject = ? AND key = ?", rec.Subject, rec.Key).
+		Updates(map[string]any{
+			"status":   rec.Status,
+			"response": rec.Response,
+		}).Error
+}
+
+func (r *GormIdempotencyRepository) ReleaseIdempotencyKey(ctx context.Context, subject uuid.UUID, key string) error {
+	return r.db.WithContext(ctx).
+		Delete(&entities.IdempotencyKeyEntity{}, "subject = ? AND key = ?", subject, key).Error
+}
+
+func (r *GormIdempotencyRepository) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Delete(&entities.IdempotencyKeyEntity{}, "expires_at <= ?", now)
+	return result.RowsAffected, result.Error
+}
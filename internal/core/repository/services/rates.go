@@ -0,0 +1,133 @@
+package repository_services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type GormExchangeRateRepository struct {
+	db *gorm.DB
+}
+
+func NewExchangeRateRepository(db *gorm.DB) services.ExchangeRateRepository {
+	return &GormExchangeRateRepository{db: db}
+}
+
+func (r *GormExchangeRateRepository) CreateExchangeRate(ctx context.Context, rate *services.ExchangeRate) error {
+	rateEntity := entities.NewExchangeRateEntityFromLogic(rate)
+
+	result := r.db.WithContext(ctx).Create(&rateEntity)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return services.ErrAlreadyExists
+		}
+		return result.Error
+	}
+
+	*rate = *rateEntity.ToLogicExchangeRate()
+
+	return nil
+}
+
+func (r *GormExchangeRateRepository) GetExchangeRate(ctx context.Context, ID uuid.UUID) (*services.ExchangeRate, error) {
+	var rateEntity *entities.ExchangeRateEntity
+	result := r.db.WithContext(ctx).
+		First(&rateEntity, "ID = ?", ID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, services.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return rateEntity.ToLogicExchangeRate(), nil
+}
+
+func (r *GormExchangeRateRepository) GetExchangeRates(ctx context.Context, fromCurrency, toCurrency string) ([]*services.ExchangeRate, error) {
+	var rateEntities []*entities.ExchangeRateEntity
+	db := r.db.WithContext(ctx)
+
+	if fromCurrency != "" {
+		db = db.Where("from_currency = ?", fromCurrency)
+	}
+
+	if toCurrency != "" {
+		db = db.Where("to_currency = ?", toCurrency)
+	}
+
+	result := db.Order("from_currency, to_currency, month").Find(&rateEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	rates := make([]*services.ExchangeRate, 0, len(rateEntities))
+	for _, entity := range rateEntities {
+		rates = append(rates, entity.ToLogicExchangeRate())
+	}
+
+	return rates, nil
+}
+
+func (r *GormExchangeRateRepository) UpdateExchangeRate(ctx context.Context, rate *services.ExchangeRate) error {
+	rateEntity := entities.NewExchangeRateEntityFromLogic(rate)
+
+	result := r.db.WithContext(ctx).
+		Model(&rateEntity).
+		Where("ID = ?", rate.ID).
+		Updates(rateEntity)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return services.ErrAlreadyExists
+		}
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return services.ErrNotFound
+	}
+
+	*rate = *rateEntity.ToLogicExchangeRate()
+
+	return nil
+}
+
+func (r *GormExchangeRateRepository) DeleteExchangeRate(ctx context.Context, ID uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Where("ID = ?", ID).
+		Delete(&entities.ExchangeRateEntity{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return services.ErrNotFound
+	}
+
+	return nil
+}
+
+// FindConversionRates returns the rates in both directions between any of the
+// currencies and the target that take effect no later than until.
+func (r *GormExchangeRateRepository) FindConversionRates(ctx context.Context, currencies []string, target string, until time.Time) ([]*services.ExchangeRate, error) {
+	var rateEntities []*entities.ExchangeRateEntity
+
+	result := r.db.WithContext(ctx).
+		Where("((from_currency IN ? AND to_currency = ?) OR (from_currency = ? AND to_currency IN ?))",
+			currencies, target, target, currencies).
+		Where("month <= ?", until).
+		Find(&rateEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	rates := make([]*services.ExchangeRate, 0, len(rateEntities))
+	for _, entity := range rateEntities {
+		rates = append(rates, entity.ToLogicExchangeRate())
+	}
+
+	return rates, nil
+}
@@ -2,11 +2,19 @@ package repository_services
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
 	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type GormServiceRepository struct {
@@ -17,12 +25,71 @@ func NewServiceRepository(db *gorm.DB) services.SubscriptionRepository {
 	return &GormServiceRepository{db: db}
 }
 
+type txKey struct{}
+
+// conn returns the transaction carried by ctx, if any, so that repository calls
+// made inside Transaction take part in it.
+func (r *GormServiceRepository) conn(ctx context.Context) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return r.db.WithContext(ctx)
+}
+
+// Transaction runs fn in a database transaction; a call inside a running
+// transaction joins it.
+func (r *GormServiceRepository) Transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// priceInEffect is the latest scheduled price change not later than the given
+// month expression; the price stored on the service row applies before it.
+func priceInEffect(month string) string {
+	return `SELECT service_prices.price, service_prices.currency FROM service_prices
+		WHERE service_prices.service_id = services.id
+			AND service_prices.effective_from > services.start_date
+			AND service_prices.effective_from <= ` + month + `
+		ORDER BY service_prices.effective_from DESC
+		LIMIT 1`
+}
+
+// currentPriceJoin makes the price in effect this month available as
+// current_price; it is NULL while the price on the service row applies.
+func currentPriceJoin(db *gorm.DB) *gorm.DB {
+	now := time.Now()
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return db.Joins("LEFT JOIN LATERAL ("+priceInEffect("CAST(? AS date)")+") AS current_price ON TRUE", currentMonth)
+}
+
+func currentPriceScope(db *gorm.DB) *gorm.DB {
+	return currentPriceJoin(db).
+		Select("services.*, current_price.price AS current_price, current_price.currency AS current_currency")
+}
+
+const (
+	currentPriceExpr    = "COALESCE(current_price.price, services.price)"
+	currentCurrencyExpr = "COALESCE(current_price.currency, services.currency)"
+)
+
 func (r *GormServiceRepository) CreateService(ctx context.Context, srv *services.Service) error {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.CreateService")
+	defer span.End()
+
 	serviceEntity := entities.NewServiceEntityFromLogic(srv)
 
-	result := r.db.WithContext(ctx).Create(&serviceEntity)
-	if result.Error != nil {
-		return result.Error
+	err := r.Transaction(ctx, func(ctx context.Context) error {
+		if err := r.conn(ctx).Create(&serviceEntity).Error; err != nil {
+			return err
+		}
+		return r.appendAudit(ctx, serviceEntity.ID, services.AuditActionCreate, nil)
+	})
+	if err != nil {
+		return err
 	}
 
 	*srv = *serviceEntity.ToLogicService()
@@ -31,61 +98,353 @@ func (r *GormServiceRepository) CreateService(ctx context.Context, srv *services
 }
 
 func (r *GormServiceRepository) GetService(ctx context.Context, ID uuid.UUID) (*services.Service, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.GetService")
+	defer span.End()
+
 	var serviceEntity *entities.ServiceEntity
-	result := r.db.WithContext(ctx).
+	result := r.conn(ctx).
+		Scopes(currentPriceScope).
 		First(&serviceEntity, "ID = ?", ID)
 	if result.Error != nil {
-		return nil, services.ErrNotFound
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, services.ErrNotFound
+		}
+		return nil, result.Error
 	}
 
 	return serviceEntity.ToLogicService(), nil
 }
 
-func (r *GormServiceRepository) GetServices(ctx context.Context, page, size int) ([]*services.Service, int, error) {
+// likePrefix escapes the LIKE wildcards of a literal prefix.
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// listScope applies the filters of the query; it needs currentPriceJoin.
+func listScope(query *services.ListQuery) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if query.IncludeDeleted {
+			db = db.Unscoped()
+		}
+
+		if query.UserID != nil {
+			db = db.Where("services.user_id = ?", *query.UserID)
+		}
+
+		if query.ServiceName != nil {
+			db = db.Where("services.service_name = ?", *query.ServiceName)
+		}
+
+		if query.ServiceNamePrefix != nil {
+			db = db.Where(`services.service_name ILIKE ? ESCAPE '\'`, likePrefix(*query.ServiceNamePrefix))
+		}
+
+		if query.MinPrice != nil || query.MaxPrice != nil {
+			db = db.Where(currentCurrencyExpr+" = ?", query.PriceCurrency)
+		}
+
+		if query.MinPrice != nil {
+			db = db.Where(currentPriceExpr+" >= ?", *query.MinPrice)
+		}
+
+		if query.MaxPrice != nil {
+			db = db.Where(currentPriceExpr+" <= ?", *query.MaxPrice)
+		}
+
+		if query.ActiveAt != nil {
+			db = db.Scopes(activeScope(*query.ActiveAt, query.ActiveAt.AddDate(0, 1, 0)))
+		}
+
+		return db
+	}
+}
+
+// sortColumns holds the expression each field orders by and the type cursor
+// values of the field are cast to. A missing end date sorts as the latest.
+var sortColumns = map[services.SortField]struct{ expr, cast string }{
+	services.SortByServiceName: {"services.service_name", "text"},
+	services.SortByPrice:       {currentPriceExpr, "bigint"},
+	services.SortByStartDate:   {"services.start_date", "date"},
+	services.SortByEndDate:     {"COALESCE(services.end_date, CAST('infinity' AS date))", "date"},
+	services.SortByUserID:      {"services.user_id", "uuid"},
+}
+
+// sortScope orders by keys and then by ID, all reversed when backward.
+func sortScope(keys []services.SortKey, backward bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		for _, key := range keys {
+			column := sortColumns[key.Field].expr
+			if key.Desc != backward {
+				column += " DESC"
+			}
+			db = db.Order(column)
+		}
+		if backward {
+			return db.Order("services.id DESC")
+		}
+		return db.Order("services.id")
+	}
+}
+
+// keysetScope keeps the rows past the cursor in the order of keys:
+//
+//	k1 > v1 OR (k1 = v1 AND k2 > v2) OR ... OR (k1 = v1 AND ... AND id > cursor id)
+//
+// with < for the descending keys, and every comparison flipped for a
+// backward cursor.
+func keysetScope(keys []services.SortKey, cursor *services.Cursor) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		var disjuncts []string
+		var args []any
+		var equalPrefix string
+		var equalArgs []any
+		for i, key := range keys {
+			column := sortColumns[key.Field]
+			value := fmt.Sprintf("CAST(? AS %s)", column.cast)
+			op := ">"
+			if key.Desc != cursor.Backward {
+				op = "<"
+			}
+
+			disjuncts = append(disjuncts, "("+equalPrefix+column.expr+" "+op+" "+value+")")
+			args = append(append(args, equalArgs...), cursor.Values[i])
+
+			equalPrefix += column.expr + " = " + value + " AND "
+			equalArgs = append(equalArgs, cursor.Values[i])
+		}
+
+		op := ">"
+		if cursor.Backward {
+			op = "<"
+		}
+		disjuncts = append(disjuncts, "("+equalPrefix+"services.id "+op+" ?)")
+		args = append(append(args, equalArgs...), cursor.ID)
+
+		return db.Where("("+strings.Join(disjuncts, " OR ")+")", args...)
+	}
+}
+
+// cursorAt points a cursor of the query at the row.
+func cursorAt(query *services.ListQuery, entity *entities.ServiceEntity, backward bool) *services.Cursor {
+	cursor := &services.Cursor{
+		Sort:     services.FormatSort(query.Sort),
+		ID:       entity.ID,
+		Backward: backward,
+	}
+
+	for _, key := range query.OrderKeys() {
+		var value string
+		switch key.Field {
+		case services.SortByServiceName:
+			value = entity.ServiceName
+		case services.SortByPrice:
+			price := entity.Price
+			if entity.CurrentPrice != nil {
+				price = *entity.CurrentPrice
+			}
+			value = strconv.FormatInt(price, 10)
+		case services.SortByStartDate:
+			value = entity.StartDate.Format(time.DateOnly)
+		case services.SortByEndDate:
+			value = services.EndlessDate
+			if entity.EndDate != nil {
+				value = entity.EndDate.Format(time.DateOnly)
+			}
+		case services.SortByUserID:
+			value = entity.UserID.String()
+		}
+		cursor.Values = append(cursor.Values, value)
+	}
+
+	return cursor
+}
+
+func (r *GormServiceRepository) GetServices(ctx context.Context, query *services.ListQuery) (*services.ServicePage, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.GetServices")
+	defer span.End()
+
+	page := &services.ServicePage{Services: []*services.Service{}}
+
+	if query.IncludeTotal {
+		var totalCount int64
+		err := r.conn(ctx).
+			Model(&entities.ServiceEntity{}).
+			Scopes(currentPriceJoin, listScope(query)).
+			Count(&totalCount).Error
+		if err != nil {
+			return nil, err
+		}
+
+		count := int(totalCount)
+		page.TotalCount = &count
+		if count == 0 {
+			return page, nil
+		}
+	}
+
 	var serviceEntities []*entities.ServiceEntity
-	var totalCount int64
+	if query.Keyset {
+		var err error
+		if serviceEntities, err = r.getServicesKeyset(ctx, query, page); err != nil {
+			return nil, err
+		}
+	} else {
+		offset := (query.Page - 1) * query.Size
+		if offset < 0 {
+			offset = 0
+		}
 
-	if err := r.db.WithContext(ctx).Model(&entities.ServiceEntity{}).Count(&totalCount).Error; err != nil {
-		return nil, 0, err
+		result := r.conn(ctx).
+			Scopes(currentPriceScope, listScope(query), sortScope(query.OrderKeys(), false)).
+			Offset(offset).
+			Limit(query.Size).
+			Find(&serviceEntities)
+		if result.Error != nil {
+			return nil, result.Error
+		}
 	}
 
-	if totalCount == 0 {
-		return []*services.Service{}, 0, nil
+	for _, entity := range serviceEntities {
+		page.Services = append(page.Services, entity.ToLogicService())
 	}
 
-	offset := (page - 1) * size
-	if offset < 0 {
-		offset = 0
+	return page, nil
+}
+
+// getServicesKeyset reads one row past the page to tell whether there is a
+// page beyond it; there is always one back where the cursor came from.
+func (r *GormServiceRepository) getServicesKeyset(ctx context.Context, query *services.ListQuery, page *services.ServicePage) ([]*entities.ServiceEntity, error) {
+	keys := query.OrderKeys()
+	backward := query.Cursor != nil && query.Cursor.Backward
+
+	db := r.conn(ctx).Scopes(currentPriceScope, listScope(query))
+	if query.Cursor != nil {
+		db = db.Scopes(keysetScope(keys, query.Cursor))
 	}
 
-	result := r.db.WithContext(ctx).
-		Offset(offset).
-		Limit(size).
+	var serviceEntities []*entities.ServiceEntity
+	result := db.
+		Scopes(sortScope(keys, backward)).
+		Limit(query.Size + 1).
 		Find(&serviceEntities)
 	if result.Error != nil {
-		return nil, 0, result.Error
+		return nil, result.Error
 	}
 
-	var logicServices []*services.Service
-	for _, entity := range serviceEntities {
-		logicServices = append(logicServices, entity.ToLogicService())
+	more := len(serviceEntities) > query.Size
+	if more {
+		serviceEntities = serviceEntities[:query.Size]
+	}
+	if backward {
+		slices.Reverse(serviceEntities)
+	}
+	if len(serviceEntities) == 0 {
+		return serviceEntities, nil
+	}
+
+	first, last := serviceEntities[0], serviceEntities[len(serviceEntities)-1]
+	if backward {
+		page.NextCursor = cursorAt(query, last, false)
+		if more {
+			page.PrevCursor = cursorAt(query, first, true)
+		}
+	} else {
+		if more {
+			page.NextCursor = cursorAt(query, last, false)
+		}
+		if query.Cursor != nil {
+			page.PrevCursor = cursorAt(query, first, true)
+		}
 	}
 
-	return logicServices, int(totalCount), nil
+	return serviceEntities, nil
 }
 
-func (r *GormServiceRepository) UpdateService(ctx context.Context, srv *services.Service) error {
+// exportBatchSize is how many rows StreamServices fetches from its cursor at a
+// time.
+const exportBatchSize = 500
+
+// StreamServices passes every service the query selects to fn in the list
+// order. The rows are read through a server-side cursor, a batch at a time.
+func (r *GormServiceRepository) StreamServices(ctx context.Context, query *services.ListQuery, fn func(srv *services.Service) error) error {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.StreamServices")
+	defer span.End()
+
+	stmt := r.db.WithContext(ctx).
+		Session(&gorm.Session{DryRun: true}).
+		Scopes(currentPriceScope, listScope(query), sortScope(query.OrderKeys(), false)).
+		Find(&[]*entities.ServiceEntity{}).
+		Statement
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, err := tx.Statement.ConnPool.ExecContext(ctx,
+			"DECLARE services_export NO SCROLL CURSOR FOR "+stmt.SQL.String(), stmt.Vars...)
+		if err != nil {
+			return err
+		}
+
+		for {
+			var batch []*entities.ServiceEntity
+			err := tx.Raw(fmt.Sprintf("FETCH FORWARD %d FROM services_export", exportBatchSize)).
+				Scan(&batch).Error
+			if err != nil {
+				return err
+			}
+			if len(batch) == 0 {
+				return nil
+			}
+
+			for _, entity := range batch {
+				if err := fn(entity.ToLogicService()); err != nil {
+					return err
+				}
+			}
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+}
+
+// UpdateService replaces every field of the service but its price, which
+// changes through priceChange, if any, as it would through SetServicePrice. It
+// swaps srv.Version of the service, or its current version when srv.Version is
+// zero, for the next one, once for both.
+func (r *GormServiceRepository) UpdateService(ctx context.Context, srv *services.Service, priceChange *services.PriceChange) error {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.UpdateService")
+	defer span.End()
+
 	serviceEntity := entities.NewServiceEntityFromLogic(srv)
 
-	result := r.db.WithContext(ctx).
-		Model(&serviceEntity).
-		Where("ID = ?", srv.ID).
-		Updates(serviceEntity)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return services.ErrNotFound
+	err := r.auditedMutation(ctx, srv.ID, services.AuditActionUpdate, func(tx *gorm.DB) error {
+		expected := srv.Version
+		if expected == 0 {
+			// The row is locked, so the version read is the one replaced.
+			err := tx.Model(&entities.ServiceEntity{}).
+				Select("version").
+				Where("ID = ?", srv.ID).
+				Scan(&expected).Error
+			if err != nil {
+				return err
+			}
+		}
+		serviceEntity.Version = expected + 1
+
+		result := tx.Model(&serviceEntity).
+			Where("ID = ? AND version = ?", srv.ID, expected).
+			Select("service_name", "user_id", "start_date", "end_date", "billing_period", "billing_interval", "version").
+			Updates(serviceEntity)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return services.ErrVersionMismatch
+		}
+
+		if priceChange != nil {
+			return setPrice(tx, priceChange)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	*srv = *serviceEntity.ToLogicService()
@@ -93,33 +452,88 @@ func (r *GormServiceRepository) UpdateService(ctx context.Context, srv *services
 	return nil
 }
 
-func (r *GormServiceRepository) DeleteService(ctx context.Context, ID uuid.UUID) error {
-	result := r.db.WithContext(ctx).
-		Delete(&entities.ServiceEntity{}).
-		Where("ID = ?", ID)
-	if result.Error != nil {
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		return services.ErrNotFound
+// DeleteService only marks the service deleted; it can be restored until it is
+// purged. Deleting and restoring bump the version like any other change, so
+// that a version read before them no longer matches.
+func (r *GormServiceRepository) DeleteService(ctx context.Context, ID uuid.UUID, version int64) error {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.DeleteService")
+	defer span.End()
+
+	return r.auditedMutation(ctx, ID, services.AuditActionDelete, func(tx *gorm.DB) error {
+		if version != 0 {
+			tx = tx.Where("version = ?", version)
+		}
+		result := tx.Model(&entities.ServiceEntity{}).
+			Where("id = ?", ID).
+			Updates(map[string]any{
+				"deleted_at": time.Now(),
+				"version":    gorm.Expr("version + 1"),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return services.ErrVersionMismatch
+		}
+		return nil
+	})
+}
+
+func (r *GormServiceRepository) RestoreService(ctx context.Context, ID uuid.UUID) (*services.Service, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.RestoreService")
+	defer span.End()
+
+	err := r.auditedMutation(ctx, ID, services.AuditActionRestore, func(tx *gorm.DB) error {
+		return tx.Unscoped().
+			Model(&entities.ServiceEntity{}).
+			Where("id = ?", ID).
+			Updates(map[string]any{
+				"deleted_at": nil,
+				"version":    gorm.Expr("version + 1"),
+			}).Error
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return r.GetService(ctx, ID)
 }
 
-func (r *GormServiceRepository) FilterServices(ctx context.Context, filters *services.Filters) ([]*services.Service, error) {
-	var filteredEntities []entities.ServiceEntity
-	db := r.db.WithContext(ctx)
+func filterScope(filters *services.Filters) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(filters.SrvNames) > 0 {
+			db = db.Where("services.service_name IN ?", filters.SrvNames)
+		}
+
+		if len(filters.UserIDs) > 0 {
+			db = db.Where("services.user_id IN ?", filters.UserIDs)
+		}
 
-	if len(filters.SrvNames) > 0 {
-		db = db.Where("service_name IN ?", filters.SrvNames)
+		return db.Scopes(activeScope(filters.StartDate, filters.EndDate))
 	}
+}
 
-	if len(filters.UserIDs) > 0 {
-		db = db.Where("user_id IN ?", filters.UserIDs)
+// activeScope keeps the services active at some point of [start, end).
+func activeScope(start, end time.Time) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.
+			Where("services.start_date < ?", end).
+			Where("(services.end_date IS NULL OR services.end_date > ?)", start)
 	}
+}
+
+func (r *GormServiceRepository) FilterServices(ctx context.Context, filters *services.Filters) ([]*services.Service, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.FilterServices")
+	defer span.End()
 
-	result := db.Find(&filteredEntities)
+	var filteredEntities []entities.ServiceEntity
+
+	result := r.conn(ctx).
+		Scopes(currentPriceScope, filterScope(filters)).
+		Preload("Prices", func(db *gorm.DB) *gorm.DB {
+			return db.Order("effective_from")
+		}).
+		Find(&filteredEntities)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -131,3 +545,159 @@ func (r *GormServiceRepository) FilterServices(ctx context.Context, filters *ser
 
 	return logicServices, nil
 }
+
+// chargeStep is the interval between two consecutive charges of a service.
+const chargeStep = `CASE services.billing_period
+	WHEN 'week' THEN make_interval(weeks => services.billing_interval)
+	WHEN 'quarter' THEN make_interval(months => 3 * services.billing_interval)
+	WHEN 'year' THEN make_interval(years => services.billing_interval)
+	ELSE make_interval(months => services.billing_interval)
+END`
+
+// chargeStepMinDays is the fewest days chargeStep can span, which bounds the
+// number of charges before a date.
+const chargeStepMinDays = `CASE services.billing_period
+	WHEN 'week' THEN 7
+	WHEN 'quarter' THEN 84
+	WHEN 'year' THEN 365
+	ELSE 28
+END * services.billing_interval`
+
+// AggregateCosts expands every matching service into the charges falling inside
+// the filters window and sums the prices in effect per month, service name,
+// user and currency on the database side. Every charge is offset from the start
+// date, so Postgres clamps it to the end of a short month the way
+// services.AggregateCosts does.
+func (r *GormServiceRepository) AggregateCosts(ctx context.Context, filters *services.Filters) ([]*services.CostEntry, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.AggregateCosts")
+	defer span.End()
+
+	var costEntities []*entities.CostEntryEntity
+
+	result := r.conn(ctx).
+		Model(&entities.ServiceEntity{}).
+		Select("date_trunc('month', charged.charge_at)::date AS month, services.service_name, services.user_id, "+
+			"COALESCE(price_in_effect.currency, services.currency) AS currency, "+
+			"SUM(COALESCE(price_in_effect.price, services.price)) AS amount").
+		Joins(`CROSS JOIN LATERAL (
+			SELECT charge_at FROM generate_series(
+				0,
+				(LEAST(COALESCE(services.end_date, CAST(@end AS date)), CAST(@end AS date)) - services.start_date) / (`+chargeStepMinDays+`)
+			) AS n
+			CROSS JOIN LATERAL (SELECT services.start_date + n * `+chargeStep+` AS charge_at) AS charge
+			WHERE charge_at >= CAST(@start AS timestamp)
+				AND charge_at < LEAST(COALESCE(services.end_date, CAST(@end AS date)), CAST(@end AS date))::timestamp
+		) AS charged`, sql.Named("start", filters.StartDate), sql.Named("end", filters.EndDate)).
+		Joins("LEFT JOIN LATERAL (" + priceInEffect("charged.charge_at") + ") AS price_in_effect ON TRUE").
+		Scopes(filterScope(filters)).
+		Group("month, services.service_name, services.user_id, COALESCE(price_in_effect.currency, services.currency)").
+		Order("month, services.service_name, services.user_id, COALESCE(price_in_effect.currency, services.currency)").
+		Scan(&costEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	costEntries := make([]*services.CostEntry, 0, len(costEntities))
+	for _, entity := range costEntities {
+		costEntry, err := entity.ToLogicCostEntry()
+		if err != nil {
+			return nil, err
+		}
+		costEntries = append(costEntries, costEntry)
+	}
+
+	return costEntries, nil
+}
+
+// GroupActiveServices sums the prices in effect in the month of the services
+// active in it, per billing schedule and currency.
+func (r *GormServiceRepository) GroupActiveServices(ctx context.Context, month time.Time) ([]*services.ServiceGroup, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.GroupActiveServices")
+	defer span.End()
+
+	var groupEntities []*entities.ServiceGroupEntity
+
+	result := r.conn(ctx).
+		Model(&entities.ServiceEntity{}).
+		Select("services.billing_period, services.billing_interval, "+
+			"COALESCE(price_in_effect.currency, services.currency) AS currency, "+
+			"COUNT(*) AS services, SUM(COALESCE(price_in_effect.price, services.price)) AS amount").
+		Joins("LEFT JOIN LATERAL ("+priceInEffect("CAST(? AS date)")+") AS price_in_effect ON TRUE", month).
+		Scopes(activeScope(month, month.AddDate(0, 1, 0))).
+		Group("services.billing_period, services.billing_interval, COALESCE(price_in_effect.currency, services.currency)").
+		Scan(&groupEntities)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	groups := make([]*services.ServiceGroup, 0, len(groupEntities))
+	for _, entity := range groupEntities {
+		group, err := entity.ToLogicServiceGroup()
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// SetServicePrice schedules a price change. A change that takes effect no later
+// than the start month replaces the price stored on the service row.
+func (r *GormServiceRepository) SetServicePrice(ctx context.Context, change *services.PriceChange) error {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.SetServicePrice")
+	defer span.End()
+
+	return r.auditedMutation(ctx, change.ServiceID, services.AuditActionPriceChange, func(tx *gorm.DB) error {
+		if err := setPrice(tx, change); err != nil {
+			return err
+		}
+
+		// The scheduled prices change the price the service shows.
+		return tx.Model(&entities.ServiceEntity{}).
+			Where("ID = ?", change.ServiceID).
+			UpdateColumn("version", gorm.Expr("version + 1")).Error
+	})
+}
+
+// setPrice stores the price change and leaves the version to the caller: a
+// change from the start of the service replaces its price, a later one is
+// scheduled.
+func setPrice(tx *gorm.DB, change *services.PriceChange) error {
+	var serviceEntity entities.ServiceEntity
+	if err := tx.First(&serviceEntity, "ID = ?", change.ServiceID).Error; err != nil {
+		return err
+	}
+
+	if !change.EffectiveFrom.After(serviceEntity.StartDate) {
+		return tx.Model(&serviceEntity).Updates(map[string]any{
+			"price":    change.Price.Amount,
+			"currency": change.Price.Currency,
+		}).Error
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "service_id"}, {Name: "effective_from"}},
+		DoUpdates: clause.AssignmentColumns([]string{"price", "currency"}),
+	}).Create(entities.NewServicePriceEntityFromLogic(change)).Error
+}
+
+func (r *GormServiceRepository) GetServicePrices(ctx context.Context, ID uuid.UUID) ([]*services.PriceChange, error) {
+	ctx, span := tracer.Start(ctx, "GormServiceRepository.GetServicePrices")
+	defer span.End()
+
+	var serviceEntity entities.ServiceEntity
+	result := r.conn(ctx).
+		Preload("Prices", func(db *gorm.DB) *gorm.DB {
+			return db.Order("effective_from")
+		}).
+		First(&serviceEntity, "ID = ?", ID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, services.ErrNotFound
+		}
+		return nil, result.Error
+	}
+
+	return serviceEntity.ToLogicService().Prices, nil
+}
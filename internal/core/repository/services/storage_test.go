@@ -0,0 +1,1186 @@
+package repository_services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/Owouwun/effectivemobiletest/internal/core/repository/entities"
+	"github.com/google/uuid"
+	gorm_postgres "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TEST_DATABASE_CONN must point at a database with all migrations applied.
+// Everything the test writes is rolled back.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dbConn := os.Getenv("TEST_DATABASE_CONN")
+	if dbConn == "" {
+		t.Skip("TEST_DATABASE_CONN is not set")
+	}
+
+	db, err := gorm.Open(gorm_postgres.Open(dbConn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		t.Fatalf("failed to begin transaction: %v", tx.Error)
+	}
+	t.Cleanup(func() {
+		tx.Rollback()
+	})
+
+	return tx
+}
+
+func month(year int, m time.Month) time.Time {
+	return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func day(year int, m time.Month, d int) time.Time {
+	return time.Date(year, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sortCostEntries(entries []*services.CostEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.Month.Equal(b.Month) {
+			return a.Month.Before(b.Month)
+		}
+		if a.ServiceName != b.ServiceName {
+			return a.ServiceName < b.ServiceName
+		}
+		if a.UserID != b.UserID {
+			return a.UserID.String() < b.UserID.String()
+		}
+		return a.Amount.Currency < b.Amount.Currency
+	})
+}
+
+func TestAggregateCostsMatchesInMemoryReference(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	alice, bob := uuid.New(), uuid.New()
+	end := func(t time.Time) *time.Time { return &t }
+	rub := func(amount int64) services.Money { return services.Money{Amount: amount, Currency: "RUB"} }
+	seed := []*services.Service{
+		{ServiceName: "Yandex Plus", Price: rub(40000), UserID: alice, StartDate: month(2023, time.November)},
+		{ServiceName: "Yandex Plus", Price: rub(40000), UserID: bob, StartDate: month(2024, time.March), EndDate: end(month(2024, time.September))},
+		{ServiceName: "Netflix", Price: rub(99900), UserID: alice, StartDate: month(2024, time.February), EndDate: end(month(2024, time.March))},
+		{ServiceName: "Netflix", Price: rub(99900), UserID: bob, StartDate: month(2025, time.June)},
+		{ServiceName: "Spotify", Price: rub(16900), UserID: bob, StartDate: month(2022, time.January), EndDate: end(month(2023, time.January))},
+		{ServiceName: "Spotify", Price: rub(16900), UserID: alice, StartDate: month(2024, time.May), EndDate: end(month(2024, time.May))},
+		{ServiceName: "JetBrains", Price: rub(2490000), UserID: alice, StartDate: month(2023, time.March),
+			BillingPeriod: services.BillingPeriodYear, BillingInterval: 1},
+		{ServiceName: "Hosting", Price: rub(300000), UserID: bob, StartDate: month(2024, time.January), EndDate: end(month(2025, time.October)),
+			BillingPeriod: services.BillingPeriodQuarter, BillingInterval: 2},
+		{ServiceName: "Delivery", Price: rub(19900), UserID: alice, StartDate: month(2024, time.February), EndDate: end(month(2024, time.June)),
+			BillingPeriod: services.BillingPeriodWeek, BillingInterval: 1},
+		{ServiceName: "Delivery", Price: rub(29900), UserID: bob, StartDate: month(2024, time.March),
+			BillingPeriod: services.BillingPeriodWeek, BillingInterval: 2},
+		{ServiceName: "Netflix", Price: services.Money{Amount: 1499, Currency: "USD"}, UserID: alice, StartDate: month(2024, time.August)},
+		{ServiceName: "Cloud", Price: rub(50000), UserID: alice, StartDate: day(2024, time.January, 31), EndDate: end(month(2024, time.July))},
+		{ServiceName: "Cloud", Price: rub(50000), UserID: bob, StartDate: day(2023, time.December, 30)},
+		{ServiceName: "Backup", Price: rub(120000), UserID: alice, StartDate: day(2023, time.November, 29),
+			BillingPeriod: services.BillingPeriodQuarter, BillingInterval: 1},
+		{ServiceName: "Domain", Price: rub(99000), UserID: bob, StartDate: day(2024, time.February, 29),
+			BillingPeriod: services.BillingPeriodYear, BillingInterval: 1},
+	}
+	for _, srv := range seed {
+		if err := repo.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed service: %v", err)
+		}
+	}
+
+	priceChanges := []*services.PriceChange{
+		{ServiceID: seed[0].ID, EffectiveFrom: month(2024, time.June), Price: rub(45000)},
+		{ServiceID: seed[0].ID, EffectiveFrom: month(2025, time.January), Price: rub(50000)},
+		{ServiceID: seed[1].ID, EffectiveFrom: month(2024, time.May), Price: rub(35000)},
+		{ServiceID: seed[3].ID, EffectiveFrom: month(2025, time.June), Price: rub(109900)},
+		{ServiceID: seed[6].ID, EffectiveFrom: month(2025, time.January), Price: rub(2890000)},
+		{ServiceID: seed[9].ID, EffectiveFrom: month(2024, time.July), Price: rub(34900)},
+		{ServiceID: seed[10].ID, EffectiveFrom: month(2025, time.March), Price: rub(129990)},
+	}
+	for _, change := range priceChanges {
+		if err := repo.SetServicePrice(ctx, change); err != nil {
+			t.Fatalf("failed to seed price change: %v", err)
+		}
+	}
+
+	yandex, netflix, delivery := "Yandex Plus", "Netflix", "Delivery"
+	cloud, backup, domain := "Cloud", "Backup", "Domain"
+	testCases := []struct {
+		name    string
+		filters *services.Filters
+	}{
+		{
+			name:    "whole history",
+			filters: &services.Filters{StartDate: month(2020, time.January), EndDate: month(2026, time.January)},
+		},
+		{
+			name:    "window cuts services",
+			filters: &services.Filters{StartDate: month(2024, time.April), EndDate: month(2024, time.July)},
+		},
+		{
+			name:    "window outside every service",
+			filters: &services.Filters{StartDate: month(2019, time.January), EndDate: month(2020, time.January)},
+		},
+		{
+			name:    "empty window",
+			filters: &services.Filters{StartDate: month(2024, time.April), EndDate: month(2024, time.April)},
+		},
+		{
+			name: "by service name",
+			filters: &services.Filters{
+				SrvNames:  []*string{&yandex, &netflix},
+				StartDate: month(2024, time.January),
+				EndDate:   month(2025, time.December),
+			},
+		},
+		{
+			name: "weekly charges",
+			filters: &services.Filters{
+				SrvNames:  []*string{&delivery},
+				StartDate: month(2024, time.April),
+				EndDate:   month(2024, time.September),
+			},
+		},
+		{
+			name: "charges at the ends of months",
+			filters: &services.Filters{
+				SrvNames:  []*string{&cloud, &backup, &domain},
+				StartDate: month(2023, time.November),
+				EndDate:   month(2028, time.April),
+			},
+		},
+		{
+			name: "by user",
+			filters: &services.Filters{
+				UserIDs:   []*uuid.UUID{&bob},
+				StartDate: month(2023, time.June),
+				EndDate:   month(2025, time.August),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.filters.UserIDs) == 0 {
+				tc.filters.UserIDs = []*uuid.UUID{&alice, &bob}
+			}
+
+			got, err := repo.AggregateCosts(ctx, tc.filters)
+			if err != nil {
+				t.Fatalf("AggregateCosts failed: %v", err)
+			}
+
+			filtered, err := repo.FilterServices(ctx, tc.filters)
+			if err != nil {
+				t.Fatalf("FilterServices failed: %v", err)
+			}
+			want, err := services.AggregateCosts(filtered, tc.filters)
+			if err != nil {
+				t.Fatalf("in-memory AggregateCosts failed: %v", err)
+			}
+
+			sortCostEntries(got)
+			sortCostEntries(want)
+			if len(got) == 0 && len(want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("SQL and in-memory aggregation differ\nsql:    %+v\nmemory: %+v", got, want)
+			}
+		})
+	}
+
+	// A charge clamped to a short month neither skips it nor doubles the next.
+	got, err := repo.AggregateCosts(ctx, &services.Filters{
+		SrvNames:  []*string{&cloud},
+		UserIDs:   []*uuid.UUID{&alice},
+		StartDate: month(2024, time.January),
+		EndDate:   month(2025, time.January),
+	})
+	if err != nil {
+		t.Fatalf("AggregateCosts failed: %v", err)
+	}
+	var months []time.Time
+	for _, entry := range got {
+		if entry.Amount != rub(50000) {
+			t.Errorf("%s: got %s, want a single charge of 500.00 RUB", entry.Month.Format("01-2006"), entry.Amount)
+		}
+		months = append(months, entry.Month)
+	}
+	wantMonths := []time.Time{
+		month(2024, time.January), month(2024, time.February), month(2024, time.March),
+		month(2024, time.April), month(2024, time.May), month(2024, time.June),
+	}
+	if !slices.EqualFunc(months, wantMonths, time.Time.Equal) {
+		t.Errorf("charged in %v, want %v", months, wantMonths)
+	}
+}
+
+func TestGetServicesFiltersAndSorts(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	alice, bob := uuid.New(), uuid.New()
+	end := func(t time.Time) *time.Time { return &t }
+	rub := func(amount int64) services.Money { return services.Money{Amount: amount, Currency: "RUB"} }
+	seed := []*services.Service{
+		{ServiceName: "Yandex Plus", Price: rub(40000), UserID: alice, StartDate: month(2023, time.November)},
+		{ServiceName: "Yandex Music", Price: rub(29900), UserID: alice, StartDate: month(2024, time.March), EndDate: end(month(2024, time.September))},
+		{ServiceName: "yandex_disk", Price: rub(9900), UserID: alice, StartDate: month(2024, time.January)},
+		{ServiceName: "Netflix", Price: rub(99900), UserID: alice, StartDate: month(2024, time.February)},
+		{ServiceName: "Yandex Plus", Price: rub(40000), UserID: bob, StartDate: month(2024, time.May)},
+		{ServiceName: "Netflix", Price: services.Money{Amount: 1499, Currency: "USD"}, UserID: alice, StartDate: month(2024, time.August)},
+	}
+	for _, srv := range seed {
+		if err := repo.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed service: %v", err)
+		}
+	}
+	if err := repo.DeleteService(ctx, seed[3].ID, 0); err != nil {
+		t.Fatalf("failed to delete service: %v", err)
+	}
+
+	name, prefix, wildcard := "Yandex Plus", "yandex", "yandex_"
+	minPrice, maxPrice := int64(20000), int64(40000)
+	activeAt := month(2024, time.October)
+	testCases := []struct {
+		name  string
+		query services.ListQuery
+		want  []int
+	}{
+		{
+			name:  "default order",
+			query: services.ListQuery{UserID: &alice},
+			want:  []int{0, 2, 1, 5},
+		},
+		{
+			name:  "exact name",
+			query: services.ListQuery{ServiceName: &name},
+			want:  []int{0, 4},
+		},
+		{
+			name:  "prefix is case-insensitive and literal",
+			query: services.ListQuery{UserID: &alice, ServiceNamePrefix: &wildcard},
+			want:  []int{2},
+		},
+		{
+			name:  "price range in currency",
+			query: services.ListQuery{UserID: &alice, MinPrice: &minPrice, MaxPrice: &maxPrice, PriceCurrency: "RUB"},
+			want:  []int{0, 1},
+		},
+		{
+			name:  "active at month",
+			query: services.ListQuery{UserID: &alice, ServiceNamePrefix: &prefix, ActiveAt: &activeAt},
+			want:  []int{0, 2},
+		},
+		{
+			name:  "including deleted",
+			query: services.ListQuery{UserID: &alice, ServiceName: &seed[3].ServiceName, IncludeDeleted: true},
+			want:  []int{3, 5},
+		},
+		{
+			name: "multi-key sort",
+			query: services.ListQuery{
+				UserID: &alice,
+				Sort:   []services.SortKey{{Field: services.SortByPrice, Desc: true}, {Field: services.SortByStartDate}},
+			},
+			want: []int{0, 1, 2, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.query.Page, tc.query.Size, tc.query.IncludeTotal = 1, 2, true
+
+			page, err := repo.GetServices(ctx, &tc.query)
+			if err != nil {
+				t.Fatalf("GetServices failed: %v", err)
+			}
+
+			if page.TotalCount == nil || *page.TotalCount != len(tc.want) {
+				t.Errorf("total count = %v, want %d", page.TotalCount, len(tc.want))
+			}
+			got := page.Services
+			want := tc.want[:min(len(tc.want), tc.query.Size)]
+			if len(got) != len(want) {
+				t.Fatalf("got %d services, want %d", len(got), len(want))
+			}
+			for i, srv := range got {
+				if srv.ID != seed[want[i]].ID {
+					t.Errorf("service %d is %q started %s, want %q started %s", i,
+						srv.ServiceName, srv.StartDate.Format("01-2006"),
+						seed[want[i]].ServiceName, seed[want[i]].StartDate.Format("01-2006"))
+				}
+			}
+		})
+	}
+}
+
+func TestGetServicesKeysetMatchesOffset(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	alice := uuid.New()
+	end := func(t time.Time) *time.Time { return &t }
+	rub := func(amount int64) services.Money { return services.Money{Amount: amount, Currency: "RUB"} }
+	seed := []*services.Service{
+		{ServiceName: "Yandex Plus", Price: rub(40000), StartDate: month(2023, time.November)},
+		{ServiceName: "Yandex Plus", Price: rub(40000), StartDate: month(2023, time.November)},
+		{ServiceName: "Yandex Music", Price: rub(29900), StartDate: month(2024, time.March), EndDate: end(month(2024, time.September))},
+		{ServiceName: "Netflix", Price: rub(99900), StartDate: month(2024, time.February), EndDate: end(month(2024, time.September))},
+		{ServiceName: "Netflix", Price: rub(40000), StartDate: month(2024, time.August)},
+		{ServiceName: "Spotify", Price: rub(16900), StartDate: month(2022, time.January), EndDate: end(month(2023, time.January))},
+		{ServiceName: "Hosting", Price: rub(300000), StartDate: month(2024, time.January)},
+	}
+	for _, srv := range seed {
+		srv.UserID = alice
+		if err := repo.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed service: %v", err)
+		}
+	}
+
+	ids := func(srvs []*services.Service) []uuid.UUID {
+		var ids []uuid.UUID
+		for _, srv := range srvs {
+			ids = append(ids, srv.ID)
+		}
+		return ids
+	}
+
+	for _, sort := range []string{"", "price", "-price,service_name", "-end_date", "end_date,-start_date"} {
+		t.Run("sort "+sort, func(t *testing.T) {
+			keys, err := services.ParseSort(sort)
+			if err != nil {
+				t.Fatalf("ParseSort failed: %v", err)
+			}
+
+			all, err := repo.GetServices(ctx, &services.ListQuery{UserID: &alice, Sort: keys, Page: 1, Size: len(seed)})
+			if err != nil {
+				t.Fatalf("GetServices failed: %v", err)
+			}
+			want := ids(all.Services)
+
+			query := services.ListQuery{UserID: &alice, Sort: keys, Size: 2, Keyset: true}
+			var forward []*services.ServicePage
+			for {
+				page, err := repo.GetServices(ctx, &query)
+				if err != nil {
+					t.Fatalf("GetServices failed: %v", err)
+				}
+				forward = append(forward, page)
+				if page.NextCursor == nil {
+					break
+				}
+				if len(forward) > len(seed) {
+					t.Fatal("pagination does not end")
+				}
+				query.Cursor = page.NextCursor
+			}
+
+			var got []uuid.UUID
+			for _, page := range forward {
+				got = append(got, ids(page.Services)...)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("forward pages differ from the offset order\nkeyset: %v\noffset: %v", got, want)
+			}
+			if forward[0].PrevCursor != nil {
+				t.Error("first page has a previous cursor")
+			}
+
+			for i := len(forward) - 1; i > 0; i-- {
+				query.Cursor = forward[i].PrevCursor
+				page, err := repo.GetServices(ctx, &query)
+				if err != nil {
+					t.Fatalf("GetServices failed: %v", err)
+				}
+				if !reflect.DeepEqual(ids(page.Services), ids(forward[i-1].Services)) {
+					t.Errorf("page %d read backward differs\nbackward: %v\nforward:  %v", i-1, ids(page.Services), ids(forward[i-1].Services))
+				}
+				if (page.PrevCursor == nil) != (i == 1) {
+					t.Errorf("page %d read backward has previous cursor %v", i-1, page.PrevCursor)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyBatchModes(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	alice := uuid.New()
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: alice, Role: services.RoleUser})
+
+	batch := func() []*services.BatchOperation {
+		return []*services.BatchOperation{
+			{Op: services.BatchOpCreate, Service: &services.Service{
+				ServiceName: "Yandex Plus",
+				Price:       services.Money{Amount: 40000, Currency: "RUB"},
+				StartDate:   month(2024, time.January),
+			}},
+			{Op: services.BatchOpDelete, Service: &services.Service{ID: uuid.New()}},
+		}
+	}
+	countServices := func() int {
+		page, err := srvService.GetServices(ctx, &services.ListQuery{Page: 1, Size: 10, IncludeTotal: true})
+		if err != nil {
+			t.Fatalf("GetServices failed: %v", err)
+		}
+		return *page.TotalCount
+	}
+
+	errs, err := srvService.ApplyBatch(ctx, batch(), services.BatchAtomic)
+	if err != nil {
+		t.Fatalf("atomic ApplyBatch failed: %v", err)
+	}
+	if !errors.Is(errs[0], services.ErrBatchAborted) || !errors.Is(errs[1], services.ErrNotFound) {
+		t.Errorf("atomic batch errors = %v, want batch aborted and not found", errs)
+	}
+	if n := countServices(); n != 0 {
+		t.Errorf("atomic batch left %d services behind", n)
+	}
+
+	errs, err = srvService.ApplyBatch(ctx, batch(), services.BatchBestEffort)
+	if err != nil {
+		t.Fatalf("best effort ApplyBatch failed: %v", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], services.ErrNotFound) {
+		t.Errorf("best effort batch errors = %v, want nil and not found", errs)
+	}
+	if n := countServices(); n != 1 {
+		t.Errorf("best effort batch created %d services, want 1", n)
+	}
+}
+
+func TestImportServicesCommitsAllOrNothing(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	alice := uuid.New()
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: alice, Role: services.RoleUser})
+
+	rows := func(userID uuid.UUID) []*services.Service {
+		return []*services.Service{
+			{ServiceName: "Yandex Plus", Price: services.Money{Amount: 40000, Currency: "RUB"}, StartDate: month(2024, time.January)},
+			{ServiceName: "Netflix", Price: services.Money{Amount: 1499, Currency: "USD"}, UserID: userID, StartDate: month(2024, time.March)},
+		}
+	}
+	countServices := func() int {
+		page, err := srvService.GetServices(ctx, &services.ListQuery{Page: 1, Size: 10, IncludeTotal: true})
+		if err != nil {
+			t.Fatalf("GetServices failed: %v", err)
+		}
+		return *page.TotalCount
+	}
+
+	errs, err := srvService.ImportServices(ctx, rows(uuid.New()), true)
+	if err != nil {
+		t.Fatalf("ImportServices failed: %v", err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], services.ErrForbidden) {
+		t.Errorf("import errors = %v, want nil and forbidden", errs)
+	}
+	if n := countServices(); n != 0 {
+		t.Errorf("invalid import created %d services", n)
+	}
+
+	errs, err = srvService.ImportServices(ctx, rows(alice), true)
+	if err != nil {
+		t.Fatalf("ImportServices failed: %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil {
+		t.Errorf("import errors = %v, want none", errs)
+	}
+	if n := countServices(); n != 2 {
+		t.Errorf("import created %d services, want 2", n)
+	}
+}
+
+func TestStreamServicesMatchesList(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	alice := uuid.New()
+	for i := range exportBatchSize + 10 {
+		srv := &services.Service{
+			ServiceName: fmt.Sprintf("Service %d", i%7),
+			Price:       services.Money{Amount: int64(100 * (i % 13)), Currency: "RUB"},
+			UserID:      alice,
+			StartDate:   month(2020+i%5, time.Month(1+i%12)),
+		}
+		if err := repo.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed service: %v", err)
+		}
+	}
+
+	query := &services.ListQuery{
+		UserID: &alice,
+		Sort:   []services.SortKey{{Field: services.SortByServiceName}, {Field: services.SortByPrice, Desc: true}},
+		Page:   1,
+		Size:   exportBatchSize + 10,
+	}
+	page, err := repo.GetServices(ctx, query)
+	if err != nil {
+		t.Fatalf("GetServices failed: %v", err)
+	}
+
+	var streamed []*services.Service
+	err = repo.StreamServices(ctx, query, func(srv *services.Service) error {
+		streamed = append(streamed, srv)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamServices failed: %v", err)
+	}
+
+	if len(streamed) != len(page.Services) {
+		t.Fatalf("streamed %d services, listed %d", len(streamed), len(page.Services))
+	}
+	for i := range streamed {
+		if streamed[i].ID != page.Services[i].ID {
+			t.Fatalf("service %d differs: streamed %s, listed %s", i, streamed[i].ID, page.Services[i].ID)
+		}
+	}
+}
+
+func TestIdempotencyKeysReplayAndExpire(t *testing.T) {
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+	repo := NewIdempotencyRepository(openTestDB(t))
+	svc := services.NewIdempotencyService(repo, time.Hour)
+
+	body := []byte(`{"service_name":"Netflix"}`)
+	if rec, err := svc.BeginRequest(ctx, "key", "POST", "/service", body); err != nil || rec != nil {
+		t.Fatalf("first request: %v, %v", rec, err)
+	}
+	if _, err := svc.BeginRequest(ctx, "key", "POST", "/service", body); !errors.Is(err, services.ErrIdempotencyInProgress) {
+		t.Fatalf("concurrent request: got %v", err)
+	}
+	if err := svc.CompleteRequest(ctx, "key", 201, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+
+	rec, err := svc.BeginRequest(ctx, "key", "POST", "/service", body)
+	if err != nil || rec == nil || rec.Status != 201 || string(rec.Response) != `{"id":1}` {
+		t.Fatalf("replay: %+v, %v", rec, err)
+	}
+	if _, err := svc.BeginRequest(ctx, "key", "POST", "/service", []byte(`{}`)); !errors.Is(err, services.ErrIdempotencyKeyReused) {
+		t.Fatalf("different request: got %v", err)
+	}
+
+	// Another caller has keys of its own.
+	other := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+	if rec, err := svc.BeginRequest(other, "key", "POST", "/service", []byte(`{}`)); err != nil || rec != nil {
+		t.Fatalf("other caller: %v, %v", rec, err)
+	}
+
+	expired := services.NewIdempotencyService(repo, -time.Minute)
+	if rec, err := expired.BeginRequest(ctx, "stale", "POST", "/service", body); err != nil || rec != nil {
+		t.Fatalf("stale request: %v, %v", rec, err)
+	}
+	if rec, err := expired.BeginRequest(ctx, "stale", "POST", "/service", []byte(`{}`)); err != nil || rec != nil {
+		t.Fatalf("expired key was not reclaimed: %v, %v", rec, err)
+	}
+}
+
+func TestAPIKeysSeeEveryUserUntilRevoked(t *testing.T) {
+	db := openTestDB(t)
+	repo := &GormServiceRepository{db: db}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(db))
+	keyService := services.NewAPIKeyService(NewAPIKeyRepository(db))
+	admin := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleAdmin})
+
+	users := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, userID := range users {
+		srv := &services.Service{
+			ServiceName: "Yandex Plus",
+			Price:       services.Money{Amount: 40000, Currency: "RUB"},
+			UserID:      userID,
+			StartDate:   month(2024, time.January),
+		}
+		if err := srvService.CreateService(admin, srv); err != nil {
+			t.Fatalf("CreateService failed: %v", err)
+		}
+	}
+
+	key := &services.APIKey{Name: "billing-export", Scopes: []services.Scope{services.ScopeRead}}
+	plaintext, err := keyService.CreateAPIKey(admin, key)
+	if err != nil {
+		t.Fatalf("CreateAPIKey failed: %v", err)
+	}
+
+	var stored entities.APIKeyEntity
+	if err := db.First(&stored, "id = ?", key.ID).Error; err != nil {
+		t.Fatalf("failed to read the key: %v", err)
+	}
+	if stored.SecretHash == plaintext || stored.Prefix != key.Prefix {
+		t.Errorf("stored hash %q and prefix %q for key %q", stored.SecretHash, stored.Prefix, plaintext)
+	}
+
+	principal, err := keyService.AuthenticateAPIKey(admin, plaintext)
+	if err != nil {
+		t.Fatalf("AuthenticateAPIKey failed: %v", err)
+	}
+	ctx := services.WithPrincipal(context.Background(), principal)
+	for _, userID := range users {
+		page, err := srvService.GetServices(ctx, &services.ListQuery{Page: 1, Size: 10, UserID: &userID, IncludeTotal: true})
+		if err != nil {
+			t.Fatalf("GetServices failed: %v", err)
+		}
+		if *page.TotalCount != 1 {
+			t.Errorf("API key sees %d services of user %s, want 1", *page.TotalCount, userID)
+		}
+	}
+
+	if err := keyService.RevokeAPIKey(admin, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey failed: %v", err)
+	}
+	if _, err := keyService.AuthenticateAPIKey(admin, plaintext); !errors.Is(err, services.ErrUnauthenticated) {
+		t.Errorf("revoked key: got %v, want ErrUnauthenticated", err)
+	}
+	if err := keyService.RevokeAPIKey(admin, uuid.New()); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("revoking an unknown key: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestAuditRecordsEveryChange(t *testing.T) {
+	db := openTestDB(t)
+	repo := &GormServiceRepository{db: db}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(db))
+
+	alice, admin := uuid.New(), uuid.New()
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: alice, Role: services.RoleUser})
+	adminCtx := services.WithPrincipal(context.Background(), &services.Principal{Subject: admin, Role: services.RoleAdmin})
+
+	srv := &services.Service{
+		ServiceName: "Yandex Plus",
+		Price:       services.Money{Amount: 40000, Currency: "RUB"},
+		StartDate:   month(2024, time.January),
+	}
+	if err := srvService.CreateService(ctx, srv); err != nil {
+		t.Fatalf("CreateService failed: %v", err)
+	}
+	change := &services.PriceChange{ServiceID: srv.ID, EffectiveFrom: month(2024, time.June), Price: services.Money{Amount: 45000, Currency: "RUB"}}
+	if err := srvService.SchedulePriceChange(ctx, change); err != nil {
+		t.Fatalf("SchedulePriceChange failed: %v", err)
+	}
+	renamed, err := srvService.GetService(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetService failed: %v", err)
+	}
+	renamed.ServiceName = "Kinopoisk"
+	if err := srvService.UpdateService(ctx, renamed); err != nil {
+		t.Fatalf("UpdateService failed: %v", err)
+	}
+	if err := srvService.DeleteService(ctx, srv.ID, 0); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+	if _, err := srvService.RestoreService(ctx, srv.ID); err != nil {
+		t.Fatalf("RestoreService failed: %v", err)
+	}
+	if err := srvService.DeleteService(ctx, srv.ID, 0); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+	if n, err := srvService.PurgeDeletedServices(adminCtx, -time.Minute); err != nil || n != 1 {
+		t.Fatalf("PurgeDeletedServices purged %d services: %v", n, err)
+	}
+
+	history, err := srvService.GetServiceHistory(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetServiceHistory failed: %v", err)
+	}
+	wantActions := []services.AuditAction{
+		services.AuditActionCreate,
+		services.AuditActionPriceChange,
+		services.AuditActionUpdate,
+		services.AuditActionDelete,
+		services.AuditActionRestore,
+		services.AuditActionDelete,
+		services.AuditActionPurge,
+	}
+	var actions []services.AuditAction
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	if !slices.Equal(actions, wantActions) {
+		t.Fatalf("got actions %v, want %v", actions, wantActions)
+	}
+
+	var wantKeys []string
+	snapshotKeys := func(entry *services.ServiceAuditEntry, snapshot json.RawMessage) []string {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(snapshot, &fields); err != nil {
+			t.Fatalf("%s: snapshot is not a JSON object: %v", entry.Action, err)
+		}
+		var prices []json.RawMessage
+		if err := json.Unmarshal(fields["prices"], &prices); err != nil || prices == nil {
+			t.Errorf("%s: snapshot has no prices: %s", entry.Action, snapshot)
+		}
+		return slices.Sorted(maps.Keys(fields))
+	}
+	for _, entry := range history {
+		wantActor, wantRole := alice, services.RoleUser
+		if entry.Action == services.AuditActionPurge {
+			wantActor, wantRole = admin, services.RoleAdmin
+		}
+		if entry.ActorID == nil || *entry.ActorID != wantActor || entry.ActorRole != wantRole || entry.UserID != alice {
+			t.Errorf("%s: got actor %v %s for user %s, want %s %s for %s", entry.Action, entry.ActorID, entry.ActorRole, entry.UserID, wantActor, wantRole, alice)
+		}
+
+		if (entry.Before == nil) != (entry.Action == services.AuditActionCreate) {
+			t.Errorf("%s: before is %s", entry.Action, entry.Before)
+		}
+		if (entry.After == nil) != (entry.Action == services.AuditActionPurge) {
+			t.Errorf("%s: after is %s", entry.Action, entry.After)
+		}
+		for _, snapshot := range []json.RawMessage{entry.Before, entry.After} {
+			if snapshot == nil {
+				continue
+			}
+			keys := snapshotKeys(entry, snapshot)
+			if wantKeys == nil {
+				wantKeys = keys
+			} else if !slices.Equal(keys, wantKeys) {
+				t.Errorf("%s: snapshot has fields %v, want %v", entry.Action, keys, wantKeys)
+			}
+		}
+	}
+	purged, deleted := history[len(history)-1], history[len(history)-2]
+	if !reflect.DeepEqual(decodeJSON(t, purged.Before), decodeJSON(t, deleted.After)) {
+		t.Errorf("purge kept %s, want the state after the delete %s", purged.Before, deleted.After)
+	}
+	if !strings.Contains(string(purged.Before), `"effective_from"`) {
+		t.Errorf("purge lost the scheduled prices: %s", purged.Before)
+	}
+
+	for _, stmt := range []string{
+		"UPDATE service_audit SET action = 'update' WHERE service_id = ?",
+		"DELETE FROM service_audit WHERE service_id = ?",
+	} {
+		db.SavePoint("audit")
+		if err := db.Exec(stmt, srv.ID).Error; err == nil {
+			t.Errorf("%s: the audit log is not append-only", stmt)
+		}
+		db.RollbackTo("audit")
+	}
+}
+
+func decodeJSON(t *testing.T, raw json.RawMessage) any {
+	t.Helper()
+
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		t.Fatalf("invalid JSON %s: %v", raw, err)
+	}
+	return v
+}
+
+func TestSoftDeleteRestoreAndPurge(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	alice := uuid.New()
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: alice, Role: services.RoleUser})
+	adminCtx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleAdmin})
+
+	srv := &services.Service{
+		ServiceName: "Yandex Plus",
+		Price:       services.Money{Amount: 40000, Currency: "RUB"},
+		StartDate:   month(2024, time.January),
+	}
+	if err := srvService.CreateService(ctx, srv); err != nil {
+		t.Fatalf("CreateService failed: %v", err)
+	}
+	listed := func() int {
+		page, err := srvService.GetServices(ctx, &services.ListQuery{Page: 1, Size: 10, IncludeTotal: true})
+		if err != nil {
+			t.Fatalf("GetServices failed: %v", err)
+		}
+		return *page.TotalCount
+	}
+
+	if err := srvService.DeleteService(ctx, srv.ID, srv.Version); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+	if _, err := srvService.GetService(ctx, srv.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("GetService of a deleted service: got %v, want ErrNotFound", err)
+	}
+	if n := listed(); n != 0 {
+		t.Errorf("deleted service is listed: %d services", n)
+	}
+	if err := srvService.DeleteService(ctx, srv.ID, 0); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+	}
+
+	restored, err := srvService.RestoreService(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("RestoreService failed: %v", err)
+	}
+	if restored.Version != srv.Version+2 {
+		t.Errorf("got version %d after delete and restore, want %d", restored.Version, srv.Version+2)
+	}
+	if n := listed(); n != 1 {
+		t.Errorf("restored service is not listed: %d services", n)
+	}
+	if _, err := srvService.RestoreService(ctx, srv.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("restoring a live service: got %v, want ErrNotFound", err)
+	}
+
+	// A version read before the delete must not match the restored service.
+	stale := *restored
+	stale.Version = srv.Version
+	if err := srvService.UpdateService(ctx, &stale); !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("update with the version before the delete: got %v, want ErrVersionMismatch", err)
+	}
+	if err := srvService.DeleteService(ctx, srv.ID, srv.Version); !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("delete with the version before the delete: got %v, want ErrVersionMismatch", err)
+	}
+
+	if err := srvService.DeleteService(ctx, srv.ID, restored.Version); err != nil {
+		t.Fatalf("DeleteService failed: %v", err)
+	}
+	if _, err := srvService.PurgeDeletedServices(ctx, 0); !errors.Is(err, services.ErrForbidden) {
+		t.Errorf("purge by a user: got %v, want ErrForbidden", err)
+	}
+	if n, err := srvService.PurgeDeletedServices(adminCtx, time.Hour); err != nil || n != 0 {
+		t.Errorf("purge of services deleted an hour ago removed %d: %v", n, err)
+	}
+	if n, err := srvService.PurgeDeletedServices(adminCtx, -time.Minute); err != nil || n != 1 {
+		t.Fatalf("purge removed %d services, want 1: %v", n, err)
+	}
+	if _, err := srvService.RestoreService(ctx, srv.ID); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("restoring a purged service: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestUpdateServiceComparesVersions(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	srv := &services.Service{
+		ServiceName: "Netflix",
+		Price:       services.Money{Amount: 99900, Currency: "RUB"},
+		UserID:      uuid.New(),
+		StartDate:   month(2024, time.January),
+	}
+	if err := repo.CreateService(ctx, srv); err != nil {
+		t.Fatalf("failed to seed service: %v", err)
+	}
+	if srv.Version != 1 {
+		t.Fatalf("new service at version %d, want 1", srv.Version)
+	}
+
+	update := &services.Service{ID: srv.ID, ServiceName: "Netflix Premium", Version: 1}
+	if err := repo.UpdateService(ctx, update, nil); err != nil {
+		t.Fatalf("update at the current version failed: %v", err)
+	}
+	if update.Version != 2 {
+		t.Errorf("updated service at version %d, want 2", update.Version)
+	}
+
+	stale := &services.Service{ID: srv.ID, ServiceName: "Netflix Basic", Version: 1}
+	if err := repo.UpdateService(ctx, stale, nil); !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("update at a stale version: got %v, want version mismatch", err)
+	}
+	if err := repo.DeleteService(ctx, srv.ID, 1); !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("delete at a stale version: got %v, want version mismatch", err)
+	}
+
+	// Without a version the update applies to whatever version is current.
+	if err := repo.UpdateService(ctx, &services.Service{ID: srv.ID, ServiceName: "Netflix Basic"}, nil); err != nil {
+		t.Fatalf("unconditional update failed: %v", err)
+	}
+	got, err := repo.GetService(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetService failed: %v", err)
+	}
+	if got.ServiceName != "Netflix Basic" || got.Version != 3 {
+		t.Errorf("service = %q at version %d, want Netflix Basic at version 3", got.ServiceName, got.Version)
+	}
+
+	if err := repo.DeleteService(ctx, srv.ID, 3); err != nil {
+		t.Errorf("delete at the current version failed: %v", err)
+	}
+}
+
+func TestPriceChangesBumpVersionOnce(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+
+	srv := &services.Service{
+		ServiceName: "Netflix",
+		Price:       services.Money{Amount: 99900, Currency: "RUB"},
+		StartDate:   month(2024, time.January),
+	}
+	if err := srvService.CreateService(ctx, srv); err != nil {
+		t.Fatalf("CreateService failed: %v", err)
+	}
+
+	update := *srv
+	update.ServiceName = "Netflix Premium"
+	update.Price.Amount = 129900
+	if err := srvService.UpdateService(ctx, &update); err != nil {
+		t.Fatalf("UpdateService failed: %v", err)
+	}
+	if update.Version != srv.Version+1 || update.Price.Amount != 129900 {
+		t.Errorf("renamed and repriced service at version %d with price %s, want version %d with price 1299.00", update.Version, update.Price, srv.Version+1)
+	}
+
+	change := &services.PriceChange{ServiceID: srv.ID, EffectiveFrom: time.Now().AddDate(0, 2, 0), Price: services.Money{Amount: 149900, Currency: "RUB"}}
+	if err := srvService.SchedulePriceChange(ctx, change); err != nil {
+		t.Fatalf("SchedulePriceChange failed: %v", err)
+	}
+	got, err := srvService.GetService(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetService failed: %v", err)
+	}
+	if got.Version != update.Version+1 {
+		t.Errorf("scheduled price change left version %d, want %d", got.Version, update.Version+1)
+	}
+
+	history, err := srvService.GetServiceHistory(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetServiceHistory failed: %v", err)
+	}
+	var actions []services.AuditAction
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	want := []services.AuditAction{services.AuditActionCreate, services.AuditActionUpdate, services.AuditActionPriceChange}
+	if !slices.Equal(actions, want) {
+		t.Errorf("got history %v, want %v", actions, want)
+	}
+}
+
+func TestCurrencyChangeKeepsPricesInOneCurrency(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+	thisMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+	toEUR := func(srv *services.Service) error {
+		srv.Price = services.Money{Amount: 999, Currency: "EUR"}
+		return nil
+	}
+
+	scheduled := &services.Service{ServiceName: "Netflix", Price: services.Money{Amount: 99900, Currency: "RUB"}, StartDate: month(2024, time.January)}
+	if err := srvService.CreateService(ctx, scheduled); err != nil {
+		t.Fatalf("CreateService failed: %v", err)
+	}
+	change := &services.PriceChange{ServiceID: scheduled.ID, EffectiveFrom: thisMonth.AddDate(0, 2, 0), Price: services.Money{Amount: 129900, Currency: "RUB"}}
+	if err := srvService.SchedulePriceChange(ctx, change); err != nil {
+		t.Fatalf("SchedulePriceChange failed: %v", err)
+	}
+	if _, err := srvService.PatchService(ctx, scheduled.ID, 0, toEUR); !errors.Is(err, services.ErrValidation) {
+		t.Errorf("new currency with scheduled prices: got %v, want %v", err, services.ErrValidation)
+	}
+
+	fresh := &services.Service{ServiceName: "Spotify", Price: services.Money{Amount: 16900, Currency: "RUB"}, StartDate: thisMonth}
+	if err := srvService.CreateService(ctx, fresh); err != nil {
+		t.Fatalf("CreateService failed: %v", err)
+	}
+	if _, err := srvService.PatchService(ctx, fresh.ID, 0, toEUR); err != nil {
+		t.Fatalf("new currency of the only price: %v", err)
+	}
+	prices, err := srvService.GetServicePrices(ctx, fresh.ID)
+	if err != nil {
+		t.Fatalf("GetServicePrices failed: %v", err)
+	}
+	for _, price := range prices {
+		if price.Price.Currency != "EUR" {
+			t.Errorf("price from %s in %s, want EUR", price.EffectiveFrom.Format("01-2006"), price.Price.Currency)
+		}
+	}
+}
+
+func TestPatchServiceClearsFields(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	alice := uuid.New()
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: alice, Role: services.RoleUser})
+
+	end := month(2024, time.June)
+	srv := &services.Service{
+		ServiceName:     "Netflix",
+		Price:           services.Money{Amount: 99900, Currency: "RUB"},
+		StartDate:       month(2024, time.January),
+		EndDate:         &end,
+		BillingPeriod:   services.BillingPeriodYear,
+		BillingInterval: 2,
+	}
+	if err := srvService.CreateService(ctx, srv); err != nil {
+		t.Fatalf("failed to seed service: %v", err)
+	}
+
+	patched, err := srvService.PatchService(ctx, srv.ID, srv.Version, func(srv *services.Service) error {
+		srv.EndDate = nil
+		srv.Price.Amount = 0
+		srv.BillingPeriod, srv.BillingInterval = "", 0
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("PatchService failed: %v", err)
+	}
+
+	got, err := srvService.GetService(ctx, srv.ID)
+	if err != nil {
+		t.Fatalf("GetService failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, patched) {
+		t.Errorf("patched service = %+v, stored %+v", patched, got)
+	}
+	if got.EndDate != nil || got.Price.Amount != 0 || got.BillingPeriod != services.BillingPeriodMonth || got.BillingInterval != 1 {
+		t.Errorf("patch did not clear the fields: %+v", got)
+	}
+
+	if _, err := srvService.PatchService(ctx, srv.ID, srv.Version, func(*services.Service) error { return nil }); !errors.Is(err, services.ErrVersionMismatch) {
+		t.Errorf("patch of a stale version: got %v, want version mismatch", err)
+	}
+}
+
+func TestEntryPointsShareValidation(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+
+	end := month(2023, time.December)
+	invalid := func() *services.Service {
+		return &services.Service{
+			ServiceName: " ",
+			Price:       services.Money{Amount: -100, Currency: "RUB"},
+			StartDate:   month(2024, time.January),
+			EndDate:     &end,
+		}
+	}
+	wantFields := []string{"service_name", "price", "end_date"}
+	checkFields := func(entry string, err error) {
+		t.Helper()
+		var validationErr *services.ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: got %v, want a validation error", entry, err)
+			return
+		}
+		var fields []string
+		for _, f := range validationErr.Fields {
+			fields = append(fields, f.Field)
+		}
+		if !reflect.DeepEqual(fields, wantFields) {
+			t.Errorf("%s: invalid fields %v, want %v", entry, fields, wantFields)
+		}
+	}
+
+	checkFields("create", srvService.CreateService(ctx, invalid()))
+
+	errs, err := srvService.ApplyBatch(ctx, []*services.BatchOperation{{Op: services.BatchOpCreate, Service: invalid()}}, services.BatchAtomic)
+	if err != nil {
+		t.Fatalf("ApplyBatch failed: %v", err)
+	}
+	checkFields("batch", errs[0])
+
+	errs, err = srvService.ImportServices(ctx, []*services.Service{invalid()}, false)
+	if err != nil {
+		t.Fatalf("ImportServices failed: %v", err)
+	}
+	checkFields("import", errs[0])
+
+	srv := &services.Service{ServiceName: "Netflix", Price: services.Money{Amount: 99900}, StartDate: month(2024, time.January)}
+	if err := srvService.CreateService(ctx, srv); err != nil {
+		t.Fatalf("failed to seed service: %v", err)
+	}
+	update := invalid()
+	update.ID = srv.ID
+	checkFields("update", srvService.UpdateService(ctx, update))
+
+	_, err = srvService.CumulateServices(ctx, &services.Filters{StartDate: month(2024, time.June), EndDate: month(2024, time.January)})
+	if !errors.Is(err, services.ErrValidation) {
+		t.Errorf("cumulate with an inverted window: got %v, want a validation error", err)
+	}
+}
+
+func TestSubscriptionStatsNormalizeToMonth(t *testing.T) {
+	repo := &GormServiceRepository{db: openTestDB(t)}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(repo.db))
+
+	before, err := srvService.GetSubscriptionStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetSubscriptionStats failed: %v", err)
+	}
+
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+	thisMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+	lastMonth := thisMonth.AddDate(0, -1, 0)
+	for _, srv := range []*services.Service{
+		{ServiceName: "Monthly", Price: services.Money{Amount: 30000, Currency: "EUR"}, StartDate: lastMonth},
+		{ServiceName: "Yearly", Price: services.Money{Amount: 120000, Currency: "EUR"}, StartDate: lastMonth, BillingPeriod: services.BillingPeriodYear},
+		{ServiceName: "Bimonthly", Price: services.Money{Amount: 20000, Currency: "EUR"}, StartDate: lastMonth, BillingInterval: 2},
+		{ServiceName: "Ended", Price: services.Money{Amount: 99900, Currency: "EUR"}, StartDate: lastMonth, EndDate: &thisMonth},
+	} {
+		if err := srvService.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed %s: %v", srv.ServiceName, err)
+		}
+	}
+
+	after, err := srvService.GetSubscriptionStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetSubscriptionStats failed: %v", err)
+	}
+	if n := after.ActiveServices - before.ActiveServices; n != 3 {
+		t.Errorf("active services grew by %d, want 3", n)
+	}
+	// 300 a month, 1200 a year and 200 every two months.
+	if rate := after.MonthlyRunRate["EUR"] - before.MonthlyRunRate["EUR"]; math.Abs(rate-500) > 1e-6 {
+		t.Errorf("EUR run rate grew by %v, want 500", rate)
+	}
+}
+
+func TestSumsOverflowingInt64(t *testing.T) {
+	ctx := context.Background()
+	repo := &GormServiceRepository{db: openTestDB(t)}
+
+	userID := uuid.New()
+	thisMonth := time.Date(time.Now().Year(), time.Now().Month(), 1, 0, 0, 0, 0, time.UTC)
+	for range 2 {
+		srv := &services.Service{
+			ServiceName: "Overflow",
+			Price:       services.Money{Amount: math.MaxInt64/2 + 1, Currency: "RUB"},
+			UserID:      userID,
+			StartDate:   thisMonth,
+		}
+		if err := repo.CreateService(ctx, srv); err != nil {
+			t.Fatalf("failed to seed service: %v", err)
+		}
+	}
+
+	filters := &services.Filters{UserIDs: []*uuid.UUID{&userID}, StartDate: thisMonth, EndDate: thisMonth.AddDate(0, 1, 0)}
+	if _, err := repo.AggregateCosts(ctx, filters); !errors.Is(err, services.ErrAmountOverflow) {
+		t.Errorf("AggregateCosts: got %v, want %v", err, services.ErrAmountOverflow)
+	}
+	if _, err := repo.GroupActiveServices(ctx, thisMonth); !errors.Is(err, services.ErrAmountOverflow) {
+		t.Errorf("GroupActiveServices: got %v, want %v", err, services.ErrAmountOverflow)
+	}
+}
@@ -0,0 +1,7 @@
+package repository_services
+
+import "go.opentelemetry.io/otel"
+
+// tracer spans the repository calls; the statements they run are traced by
+// the GORM plugin as their children.
+var tracer = otel.Tracer("github.com/Owouwun/effectivemobiletest/internal/core/repository/services")
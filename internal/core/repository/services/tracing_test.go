@@ -0,0 +1,93 @@
+package repository_services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Owouwun/effectivemobiletest/internal/core/logic/services"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	gorm_postgres "gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/plugin/opentelemetry/tracing"
+)
+
+// emptyDatabase answers every statement with no rows, which is all the spans
+// need.
+type emptyDatabase struct{}
+
+func (emptyDatabase) Connect(context.Context) (driver.Conn, error) { return emptyDatabase{}, nil }
+func (emptyDatabase) Driver() driver.Driver                        { return nil }
+
+func (emptyDatabase) Prepare(string) (driver.Stmt, error) { return emptyDatabase{}, nil }
+func (emptyDatabase) Begin() (driver.Tx, error)           { return emptyDatabase{}, nil }
+func (emptyDatabase) Commit() error                       { return nil }
+func (emptyDatabase) Rollback() error                     { return nil }
+func (emptyDatabase) Close() error                        { return nil }
+
+func (emptyDatabase) NumInput() int                              { return -1 }
+func (emptyDatabase) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (emptyDatabase) Query([]driver.Value) (driver.Rows, error)  { return emptyDatabase{}, nil }
+func (emptyDatabase) Columns() []string                          { return nil }
+func (emptyDatabase) Next([]driver.Value) error                  { return io.EOF }
+
+func TestCumulateSpansNestAcrossLayers(t *testing.T) {
+	db, err := gorm.Open(gorm_postgres.New(gorm_postgres.Config{Conn: sql.OpenDB(emptyDatabase{})}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open the database: %v", err)
+	}
+	if err := db.Use(tracing.NewPlugin(tracing.WithoutMetrics())); err != nil {
+		t.Fatalf("failed to install the GORM tracing plugin: %v", err)
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+	})
+
+	repo := &GormServiceRepository{db: db}
+	srvService := services.NewSubscriptionService(repo, NewExchangeRateRepository(db))
+	ctx := services.WithPrincipal(context.Background(), &services.Principal{Subject: uuid.New(), Role: services.RoleUser})
+
+	filters := &services.Filters{StartDate: month(2024, time.January), EndDate: month(2024, time.December)}
+	if _, err := srvService.CumulateServices(ctx, filters); err != nil {
+		t.Fatalf("CumulateServices failed: %v", err)
+	}
+
+	ended := exporter.GetSpans()
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range ended {
+		spans[span.Name] = span
+	}
+	cumulate, okCumulate := spans["SubscriptionService.CumulateServices"]
+	aggregate, okAggregate := spans["GormServiceRepository.AggregateCosts"]
+	if !okCumulate || !okAggregate {
+		t.Fatalf("got spans %v, want the service and repository spans of the cumulation", slices.Collect(maps.Keys(spans)))
+	}
+	if aggregate.Parent.SpanID() != cumulate.SpanContext.SpanID() || aggregate.SpanContext.TraceID() != cumulate.SpanContext.TraceID() {
+		t.Error("the repository span is not a child of the service span")
+	}
+
+	statements := 0
+	for _, span := range ended {
+		if span.Parent.SpanID() == aggregate.SpanContext.SpanID() {
+			statements++
+		}
+	}
+	if statements == 0 {
+		t.Error("the repository span has no statement spans")
+	}
+}
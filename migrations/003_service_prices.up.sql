@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS service_prices (
+    service_id UUID NOT NULL REFERENCES services(id) ON DELETE CASCADE,
+    effective_from DATE NOT NULL,
+    price INT NOT NULL,
+    PRIMARY KEY (service_id, effective_from)
+);
\ No newline at end of file
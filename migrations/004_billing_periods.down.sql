@@ -0,0 +1,3 @@
+ALTER TABLE services
+    DROP COLUMN IF EXISTS billing_interval,
+    DROP COLUMN IF EXISTS billing_period;
\ No newline at end of file
@@ -0,0 +1,5 @@
+ALTER TABLE services
+    ADD COLUMN IF NOT EXISTS billing_period TEXT NOT NULL DEFAULT 'month'
+        CHECK (billing_period IN ('week', 'month', 'quarter', 'year')),
+    ADD COLUMN IF NOT EXISTS billing_interval INT NOT NULL DEFAULT 1
+        CHECK (billing_interval > 0);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exchange_rates;
+DROP INDEX IF EXISTS idx_services_currency;
+ALTER TABLE services DROP COLUMN IF EXISTS currency;
\ No newline at end of file
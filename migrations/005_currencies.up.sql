@@ -0,0 +1,13 @@
+ALTER TABLE services
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'RUB';
+
+CREATE INDEX IF NOT EXISTS idx_services_currency ON services(currency);
+
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    from_currency CHAR(3) NOT NULL,
+    to_currency CHAR(3) NOT NULL,
+    month DATE NOT NULL,
+    rate NUMERIC NOT NULL CHECK (rate > 0),
+    UNIQUE (from_currency, to_currency, month)
+);
\ No newline at end of file
@@ -0,0 +1,16 @@
+CREATE OR REPLACE FUNCTION pg_temp.minor_unit_scale(currency CHAR(3)) RETURNS BIGINT AS $$
+    SELECT CASE
+        WHEN currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+        WHEN currency IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW',
+                          'PYG', 'RWF', 'UGX', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1
+        ELSE 100
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE service_prices
+    ALTER COLUMN price TYPE INT USING ROUND(price::NUMERIC / pg_temp.minor_unit_scale(currency))::INT;
+
+ALTER TABLE service_prices DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE services
+    ALTER COLUMN price TYPE INT USING ROUND(price::NUMERIC / pg_temp.minor_unit_scale(currency))::INT;
\ No newline at end of file
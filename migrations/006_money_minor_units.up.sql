@@ -0,0 +1,23 @@
+CREATE OR REPLACE FUNCTION pg_temp.minor_unit_scale(currency CHAR(3)) RETURNS BIGINT AS $$
+    SELECT CASE
+        WHEN currency IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 1000
+        WHEN currency IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW',
+                          'PYG', 'RWF', 'UGX', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 1
+        ELSE 100
+    END
+$$ LANGUAGE SQL IMMUTABLE;
+
+ALTER TABLE services
+    ALTER COLUMN price TYPE BIGINT USING price::BIGINT * pg_temp.minor_unit_scale(currency);
+
+ALTER TABLE service_prices
+    ADD COLUMN IF NOT EXISTS currency CHAR(3);
+
+UPDATE service_prices
+SET currency = services.currency
+FROM services
+WHERE services.id = service_prices.service_id;
+
+ALTER TABLE service_prices
+    ALTER COLUMN currency SET NOT NULL,
+    ALTER COLUMN price TYPE BIGINT USING price::BIGINT * pg_temp.minor_unit_scale(currency);
\ No newline at end of file
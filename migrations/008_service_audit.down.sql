@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS service_audit;
+DROP FUNCTION IF EXISTS service_audit_append_only();
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS service_audit (
+    id BIGSERIAL PRIMARY KEY,
+    service_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    actor_id UUID,
+    actor_role TEXT,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete', 'price_change')),
+    before JSONB,
+    after JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_service_audit_service_id ON service_audit(service_id, created_at);
+
+CREATE OR REPLACE FUNCTION service_audit_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'service_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER service_audit_append_only
+    BEFORE UPDATE OR DELETE ON service_audit
+    FOR EACH STATEMENT EXECUTE FUNCTION service_audit_append_only();
\ No newline at end of file
@@ -0,0 +1,14 @@
+DELETE FROM services WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS idx_services_deleted_at;
+ALTER TABLE services DROP COLUMN IF EXISTS deleted_at;
+
+-- The actions 008 does not know cannot stay in the append-only audit.
+ALTER TABLE service_audit DISABLE TRIGGER service_audit_append_only;
+DELETE FROM service_audit WHERE action IN ('restore', 'purge');
+ALTER TABLE service_audit ENABLE TRIGGER service_audit_append_only;
+
+ALTER TABLE service_audit
+    DROP CONSTRAINT IF EXISTS service_audit_action_check,
+    ADD CONSTRAINT service_audit_action_check
+        CHECK (action IN ('create', 'update', 'delete', 'price_change'));
\ No newline at end of file
@@ -0,0 +1,9 @@
+ALTER TABLE services
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_services_deleted_at ON services(deleted_at);
+
+ALTER TABLE service_audit
+    DROP CONSTRAINT IF EXISTS service_audit_action_check,
+    ADD CONSTRAINT service_audit_action_check
+        CHECK (action IN ('create', 'update', 'delete', 'price_change', 'restore', 'purge'));
\ No newline at end of file
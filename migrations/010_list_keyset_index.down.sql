@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_services_user_start_date_id;
\ No newline at end of file
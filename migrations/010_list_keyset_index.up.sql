@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_services_user_start_date_id ON services(user_id, start_date, id)
+    WHERE deleted_at IS NULL;
\ No newline at end of file